@@ -2,11 +2,14 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"nyooom/logging"
 	"os"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/valkey-io/valkey-go"
@@ -15,15 +18,45 @@ import (
 type BasicDB interface {
 	Exists(ctx context.Context, key string) (bool, error)
 	Set(ctx context.Context, key string, value string, duration time.Duration) error
+	SetIfNotExists(ctx context.Context, key string, value string, duration time.Duration) (bool, error)
 	Get(ctx context.Context, key string) (string, error)
 	Delete(ctx context.Context, key string) error
 	SetHash(ctx context.Context, key string, values map[string]string) error
 	GetHash(ctx context.Context, key string) (map[string]string, error)
+	GetHashes(ctx context.Context, keys []string) ([]map[string]string, error)
 	DeleteHash(ctx context.Context, key string) error
 	AddToList(ctx context.Context, key string, value string) error
 	RemoveFromList(ctx context.Context, key string, value string) error
 	GetList(ctx context.Context, key string) ([]string, error)
+	AddToSet(ctx context.Context, key string, value string) error
+	RemoveFromSet(ctx context.Context, key string, value string) error
+	GetSet(ctx context.Context, key string) ([]string, error)
+	GetSets(ctx context.Context, keys []string) ([][]string, error)
 	IncrementHashField(ctx context.Context, key string, field string, amount int) error
+	// Transaction runs fn and then applies its queued writes atomically, as long as none of the
+	// watched keys changed in the meantime. Otherwise fn is run again against the fresh data.
+	Transaction(ctx context.Context, keys []string, fn func(tx Tx) error) error
+}
+
+// Tx is the view of the database inside a transaction.
+// Reads happen immediately and see the state from before the transaction.
+// Writes are queued and only applied once the transaction function returns without an error.
+type Tx interface {
+	Exists(ctx context.Context, key string) (bool, error)
+	Get(ctx context.Context, key string) (string, error)
+	GetHash(ctx context.Context, key string) (map[string]string, error)
+	GetList(ctx context.Context, key string) ([]string, error)
+	GetSet(ctx context.Context, key string) ([]string, error)
+	Set(key string, value string, duration time.Duration)
+	Delete(key string)
+	Rename(key string, newKey string)
+	SetHash(key string, values map[string]string)
+	AddToList(key string, value string)
+	SetListItem(key string, index int, value string)
+	RemoveFromList(key string, value string)
+	AddToSet(key string, value string)
+	RemoveFromSet(key string, value string)
+	IncrementHashField(key string, field string, amount int)
 }
 
 type ValkeyDB struct {
@@ -36,9 +69,23 @@ type AdvancedDB interface {
 	SetVersion(ctx context.Context, version string) error
 	SetLink(ctx context.Context, link Link) error
 	GetLink(ctx context.Context, linkSlug string) (Link, error)
-	DeleteLink(ctx context.Context, linkSlug string) error
+	IsSlugFree(ctx context.Context, slug string) (bool, error)
+	ResolveLink(ctx context.Context, slug string) (Link, error)
+	AddAlias(ctx context.Context, linkSlug string, alias string) error
+	RemoveAlias(ctx context.Context, linkSlug string, alias string) error
+	UpdateLink(ctx context.Context, linkSlug string, newSlug string, actor string, change func(link *Link) error) error
+	UpdateLinksTags(ctx context.Context, linkSlugs []string, addTags []string, removeTags []string) ([]Link, error)
+	SetLinksDisabled(ctx context.Context, linkSlugs []string, disabled bool, actor string) ([]Link, error)
+	RollbackLink(ctx context.Context, linkSlug string, url string, actor string) error
+	GetLinkHistory(ctx context.Context, linkSlug string) ([]HistoryEntry, error)
+	TrashLink(ctx context.Context, linkSlug string, actor string) error
+	RestoreLink(ctx context.Context, linkSlug string, actor string) (Link, error)
+	PurgeLink(ctx context.Context, linkSlug string) error
+	GetTrash(ctx context.Context, retention time.Duration) ([]TrashedLink, error)
+	PurgeExpiredTrash(ctx context.Context, retention time.Duration) (int, error)
 	GetLinkSlugs(ctx context.Context) ([]string, error)
-	GetLinks(ctx context.Context) ([]Link, error)
+	GetLinks(ctx context.Context, query LinkQuery) (LinkPage, error)
+	GetTags(ctx context.Context) ([]TagCount, error)
 	GetJWTSecret(ctx context.Context) (string, error)
 	SetJWTSecret(ctx context.Context, jwt string) error
 	UserExists(ctx context.Context) (bool, error)
@@ -48,12 +95,71 @@ type AdvancedDB interface {
 }
 
 type DB struct {
-	basicDB BasicDB
+	basicDB  BasicDB
+	timeFunc TimeFunc // For when links are created and updated
 }
 
+var (
+	ErrKeyNotFound  = errors.New("key does not exist")
+	ErrLinkNotFound = errors.New("link does not exist")
+	ErrLinkExists   = errors.New("link already exists")
+	ErrUserNotFound = errors.New("user does not exist")
+	ErrLinkUsedUp   = errors.New("link has reached its click limit")
+	ErrTooManyTags  = errors.New("link has too many tags")
+
+	ErrUnknownDestination = errors.New("link has never pointed there")
+)
+
+// internalKeys live alongside links in the keyspace, so they can't be used as slugs
+var internalKeys = []string{"version", "jwt", "user-hash", "links", allTagsKey, trashedLinksKey, migrationLockKey}
+
 // Basic DB functions to have more complex DBs implement
 
 func SetupDB() AdvancedDB {
+	// Pick the storage backend
+	var basicDB BasicDB
+	rebuildSearch := false
+	switch dbType := strings.ToLower(os.Getenv("DB_TYPE")); dbType {
+	case "", "valkey":
+		basicDB = setupValkeyDB()
+	case "memory":
+		logging.Println("Using in-memory database, data will be lost on restart")
+		basicDB = NewMemoryDB(time.Now)
+	case "file":
+		dbPath := os.Getenv("DB_PATH")
+		if dbPath == "" {
+			dbPath = "Nyooom/nyooom-db.json"
+		}
+		logging.Println("Using file database at " + dbPath)
+		fileDB, err := NewFileDB(dbPath, time.Now)
+		if err != nil {
+			panic("Failed to open file database: " + err.Error())
+		}
+		basicDB = fileDB
+		rebuildSearch = true
+	default:
+		panic("Unknown DB_TYPE: " + dbType)
+	}
+
+	// Save DB
+	db := DB{
+		basicDB:  basicDB,
+		timeFunc: time.Now,
+	}
+
+	// Bring the DB up to date
+	db.migrate()
+	if rebuildSearch { // The file leaves the search index out
+		err := indexAllLinks(context.Background(), db)
+		if err != nil {
+			panic("Failed to index links for search: " + err.Error())
+		}
+	}
+
+	return db
+}
+
+func setupValkeyDB() *ValkeyDB {
 	// Read arguments
 	dbPort, err := strconv.Atoi(os.Getenv("DB_PORT"))
 	if err != nil {
@@ -79,30 +185,9 @@ func SetupDB() AdvancedDB {
 		panic("Failed to connect to Valkey: " + err.Error())
 	}
 
-	// Save DB
-	db := DB{
-		basicDB: &ValkeyDB{
-			db:     dbClient,
-			prefix: "Nyooom:",
-		},
-	}
-
-	// Check if DB is up to date
-	db.versioning()
-
-	return db
-}
-
-func (db DB) versioning() {
-	expectedDBVersion := "1"
-	currentVersion, err := db.GetVersion(context.Background())
-	if err != nil || currentVersion != expectedDBVersion {
-		logging.PrintErrStr("Failed to get current version: " + err.Error() + ". Attempting to set version to " + expectedDBVersion)
-		err = db.SetVersion(context.Background(), expectedDBVersion)
-		if err != nil {
-			panic("Failed to set version: " + err.Error())
-		}
-		return
+	return &ValkeyDB{
+		db:     dbClient,
+		prefix: "Nyooom:",
 	}
 }
 
@@ -116,7 +201,9 @@ func (db *ValkeyDB) Exists(ctx context.Context, key string) (bool, error) {
 
 func (db *ValkeyDB) Get(ctx context.Context, key string) (string, error) {
 	value, err := db.db.Do(ctx, db.db.B().Get().Key(db.prefix+key).Build()).ToString()
-	if err != nil {
+	if valkey.IsValkeyNil(err) {
+		return "", fmt.Errorf("Could not get key %s: %w", key, ErrKeyNotFound)
+	} else if err != nil {
 		return "", errors.New("Could not get key " + key + ": " + err.Error())
 	}
 	return value, nil
@@ -139,6 +226,24 @@ func (db *ValkeyDB) Set(ctx context.Context, key string, value string, duration
 	return nil
 }
 
+// Sets a key in the database with some duration only if the key is not already set.
+// Returns whether the key was set. If duration is 0, the key will be set with no expiration.
+func (db *ValkeyDB) SetIfNotExists(ctx context.Context, key string, value string, duration time.Duration) (bool, error) {
+	command := db.db.B().Set().Key(db.prefix + key).Value(value).Nx()
+	var err error
+	if duration == 0 {
+		err = db.db.Do(ctx, command.Build()).Error()
+	} else {
+		err = db.db.Do(ctx, command.Ex(duration).Build()).Error()
+	}
+	if valkey.IsValkeyNil(err) {
+		return false, nil
+	} else if err != nil {
+		return false, errors.New("Could not set key " + key + " if not exists: " + err.Error())
+	}
+	return true, nil
+}
+
 func (db *ValkeyDB) Delete(ctx context.Context, key string) error {
 	err := db.db.Do(ctx, db.db.B().Del().Key(db.prefix+key).Build()).Error()
 	if err != nil {
@@ -155,6 +260,27 @@ func (db *ValkeyDB) GetHash(ctx context.Context, key string) (map[string]string,
 	return hash, nil
 }
 
+// GetHashes fetches many hashes in a single pipelined round trip.
+// Missing keys give empty hashes, in the same position as their key.
+func (db *ValkeyDB) GetHashes(ctx context.Context, keys []string) ([]map[string]string, error) {
+	if len(keys) == 0 {
+		return []map[string]string{}, nil
+	}
+	commands := make(valkey.Commands, len(keys))
+	for i, key := range keys {
+		commands[i] = db.db.B().Hgetall().Key(db.prefix + key).Build()
+	}
+	hashes := make([]map[string]string, len(keys))
+	for i, result := range db.db.DoMulti(ctx, commands...) {
+		hash, err := result.AsStrMap()
+		if err != nil {
+			return nil, errors.New("Could not get hash for key " + keys[i] + ": " + err.Error())
+		}
+		hashes[i] = hash
+	}
+	return hashes, nil
+}
+
 func (db *ValkeyDB) SetHash(ctx context.Context, key string, values map[string]string) error {
 	hash := db.db.B().Hset().Key(db.prefix + key).FieldValue()
 	for field, value := range values {
@@ -199,6 +325,51 @@ func (db *ValkeyDB) GetList(ctx context.Context, key string) ([]string, error) {
 	return list, nil
 }
 
+func (db *ValkeyDB) AddToSet(ctx context.Context, key string, value string) error {
+	err := db.db.Do(ctx, db.db.B().Sadd().Key(db.prefix+key).Member(value).Build()).Error()
+	if err != nil {
+		return errors.New("Could not add value " + value + " to set " + key + ": " + err.Error())
+	}
+	return nil
+}
+
+func (db *ValkeyDB) RemoveFromSet(ctx context.Context, key string, value string) error {
+	err := db.db.Do(ctx, db.db.B().Srem().Key(db.prefix+key).Member(value).Build()).Error()
+	if err != nil {
+		return errors.New("Could not remove value " + value + " from set " + key + ": " + err.Error())
+	}
+	return nil
+}
+
+func (db *ValkeyDB) GetSet(ctx context.Context, key string) ([]string, error) {
+	set, err := db.db.Do(ctx, db.db.B().Smembers().Key(db.prefix+key).Build()).AsStrSlice()
+	if err != nil {
+		return nil, errors.New("Could not get set " + key + ": " + err.Error())
+	}
+	return set, nil
+}
+
+// GetSets fetches many sets in a single pipelined round trip.
+// Missing keys give empty sets, in the same position as their key.
+func (db *ValkeyDB) GetSets(ctx context.Context, keys []string) ([][]string, error) {
+	if len(keys) == 0 {
+		return [][]string{}, nil
+	}
+	commands := make(valkey.Commands, len(keys))
+	for i, key := range keys {
+		commands[i] = db.db.B().Smembers().Key(db.prefix + key).Build()
+	}
+	sets := make([][]string, len(keys))
+	for i, result := range db.db.DoMulti(ctx, commands...) {
+		set, err := result.AsStrSlice()
+		if err != nil {
+			return nil, errors.New("Could not get set " + keys[i] + ": " + err.Error())
+		}
+		sets[i] = set
+	}
+	return sets, nil
+}
+
 func (db *ValkeyDB) IncrementHashField(ctx context.Context, key string, field string, amount int) error {
 	err := db.db.Do(ctx, db.db.B().Hincrby().Key(db.prefix+key).Field(field).Increment(int64(amount)).Build()).Error()
 	if err != nil {
@@ -207,12 +378,165 @@ func (db *ValkeyDB) IncrementHashField(ctx context.Context, key string, field st
 	return nil
 }
 
+const maxTransactionAttempts = 10
+
+func (db *ValkeyDB) Transaction(ctx context.Context, keys []string, fn func(tx Tx) error) error {
+	watchKeys := make([]string, len(keys))
+	for i, key := range keys {
+		watchKeys[i] = db.prefix + key
+	}
+
+	for range maxTransactionAttempts {
+		committed := false
+		err := db.db.Dedicated(func(client valkey.DedicatedClient) error {
+			if len(watchKeys) > 0 {
+				err := client.Do(ctx, client.B().Watch().Key(watchKeys...).Build()).Error()
+				if err != nil {
+					return errors.New("Could not watch keys for transaction: " + err.Error())
+				}
+			}
+			tx := &valkeyTx{client: client, prefix: db.prefix}
+			err := fn(tx)
+			if err != nil || len(tx.queued) == 0 {
+				client.Do(ctx, client.B().Unwatch().Build())
+				committed = err == nil
+				return err
+			}
+
+			commands := make(valkey.Commands, 0, len(tx.queued)+2)
+			commands = append(commands, client.B().Multi().Build())
+			commands = append(commands, tx.queued...)
+			commands = append(commands, client.B().Exec().Build())
+			results := client.DoMulti(ctx, commands...)
+			execResult := results[len(results)-1]
+			if valkey.IsValkeyNil(execResult.Error()) { // A watched key changed, try again
+				return nil
+			}
+			written, err := execResult.ToArray()
+			if err != nil {
+				return errors.New("Could not commit transaction: " + err.Error())
+			}
+			for _, result := range written {
+				if err := result.Error(); err != nil {
+					return errors.New("Could not commit transaction: " + err.Error())
+				}
+			}
+			committed = true
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if committed {
+			return nil
+		}
+	}
+	return errors.New("Transaction gave up after " + strconv.Itoa(maxTransactionAttempts) + " conflicting attempts")
+}
+
+// valkeyTx reads through the dedicated connection holding the WATCH, and queues writes for MULTI/EXEC
+type valkeyTx struct {
+	client valkey.DedicatedClient
+	prefix string
+	queued valkey.Commands
+}
+
+func (tx *valkeyTx) Exists(ctx context.Context, key string) (bool, error) {
+	exists, err := tx.client.Do(ctx, tx.client.B().Exists().Key(tx.prefix+key).Build()).AsBool()
+	if err != nil {
+		return false, errors.New("Could not check if key " + key + " exists: " + err.Error())
+	}
+	return exists, nil
+}
+
+func (tx *valkeyTx) Get(ctx context.Context, key string) (string, error) {
+	value, err := tx.client.Do(ctx, tx.client.B().Get().Key(tx.prefix+key).Build()).ToString()
+	if valkey.IsValkeyNil(err) {
+		return "", fmt.Errorf("Could not get key %s: %w", key, ErrKeyNotFound)
+	} else if err != nil {
+		return "", errors.New("Could not get key " + key + ": " + err.Error())
+	}
+	return value, nil
+}
+
+func (tx *valkeyTx) GetHash(ctx context.Context, key string) (map[string]string, error) {
+	hash, err := tx.client.Do(ctx, tx.client.B().Hgetall().Key(tx.prefix+key).Build()).AsStrMap()
+	if err != nil {
+		return nil, errors.New("Could not get hash for key " + key + ": " + err.Error())
+	}
+	return hash, nil
+}
+
+func (tx *valkeyTx) GetList(ctx context.Context, key string) ([]string, error) {
+	list, err := tx.client.Do(ctx, tx.client.B().Lrange().Key(tx.prefix+key).Start(0).Stop(-1).Build()).AsStrSlice()
+	if err != nil {
+		return nil, errors.New("Could not get list " + key + ": " + err.Error())
+	}
+	return list, nil
+}
+
+func (tx *valkeyTx) GetSet(ctx context.Context, key string) ([]string, error) {
+	set, err := tx.client.Do(ctx, tx.client.B().Smembers().Key(tx.prefix+key).Build()).AsStrSlice()
+	if err != nil {
+		return nil, errors.New("Could not get set " + key + ": " + err.Error())
+	}
+	return set, nil
+}
+
+func (tx *valkeyTx) Set(key string, value string, duration time.Duration) {
+	if duration == 0 {
+		tx.queued = append(tx.queued, tx.client.B().Set().Key(tx.prefix+key).Value(value).Build())
+	} else {
+		tx.queued = append(tx.queued, tx.client.B().Set().Key(tx.prefix+key).Value(value).Ex(duration).Build())
+	}
+}
+
+func (tx *valkeyTx) Delete(key string) {
+	tx.queued = append(tx.queued, tx.client.B().Del().Key(tx.prefix+key).Build())
+}
+
+func (tx *valkeyTx) Rename(key string, newKey string) {
+	tx.queued = append(tx.queued, tx.client.B().Rename().Key(tx.prefix+key).Newkey(tx.prefix+newKey).Build())
+}
+
+func (tx *valkeyTx) SetHash(key string, values map[string]string) {
+	hash := tx.client.B().Hset().Key(tx.prefix + key).FieldValue()
+	for field, value := range values {
+		hash = hash.FieldValue(field, value)
+	}
+	tx.queued = append(tx.queued, hash.Build())
+}
+
+func (tx *valkeyTx) AddToList(key string, value string) {
+	tx.queued = append(tx.queued, tx.client.B().Lpush().Key(tx.prefix+key).Element(value).Build())
+}
+
+func (tx *valkeyTx) SetListItem(key string, index int, value string) {
+	tx.queued = append(tx.queued, tx.client.B().Lset().Key(tx.prefix+key).Index(int64(index)).Element(value).Build())
+}
+
+func (tx *valkeyTx) RemoveFromList(key string, value string) {
+	tx.queued = append(tx.queued, tx.client.B().Lrem().Key(tx.prefix+key).Count(1).Element(value).Build())
+}
+
+func (tx *valkeyTx) AddToSet(key string, value string) {
+	tx.queued = append(tx.queued, tx.client.B().Sadd().Key(tx.prefix+key).Member(value).Build())
+}
+
+func (tx *valkeyTx) RemoveFromSet(key string, value string) {
+	tx.queued = append(tx.queued, tx.client.B().Srem().Key(tx.prefix+key).Member(value).Build())
+}
+
+func (tx *valkeyTx) IncrementHashField(key string, field string, amount int) {
+	tx.queued = append(tx.queued, tx.client.B().Hincrby().Key(tx.prefix+key).Field(field).Increment(int64(amount)).Build())
+}
+
 // Complex DB functions to have more complex DBs implement
 
 func (db DB) GetVersion(ctx context.Context) (string, error) {
 	version, err := db.basicDB.Get(ctx, "version")
 	if err != nil {
-		return "", errors.New("Could not get db version: " + err.Error())
+		return "", fmt.Errorf("Could not get db version: %w", err)
 	}
 	return version, nil
 }
@@ -220,7 +544,7 @@ func (db DB) GetVersion(ctx context.Context) (string, error) {
 func (db DB) SetVersion(ctx context.Context, version string) error {
 	err := db.basicDB.Set(ctx, "version", version, 0)
 	if err != nil {
-		return errors.New("Could not set db version: " + err.Error())
+		return fmt.Errorf("Could not set db version: %w", err)
 	}
 	return nil
 }
@@ -228,87 +552,1020 @@ func (db DB) SetVersion(ctx context.Context, version string) error {
 func (db DB) GetLink(ctx context.Context, linkSlug string) (Link, error) {
 	rawLink, err := db.basicDB.GetHash(ctx, linkSlug)
 	if err != nil {
-		return Link{}, errors.New("Could not get link " + linkSlug + ": " + err.Error())
+		return Link{}, fmt.Errorf("Could not get link %s: %w", linkSlug, err)
+	}
+	return linkFromHash(linkSlug, rawLink)
+}
+
+// ResolveLink finds the link a visitor means by a slug, following aliases and, when enabled, ignoring case
+func (db DB) ResolveLink(ctx context.Context, slug string) (Link, error) {
+	link, err := db.GetLink(ctx, slug)
+	if !errors.Is(err, ErrLinkNotFound) {
+		return link, err
+	}
+	linkSlug, err := db.basicDB.Get(ctx, aliasKey(slug))
+	if errors.Is(err, ErrKeyNotFound) && caseInsensitiveSlugs {
+		linkSlug, err = db.basicDB.Get(ctx, foldKey(slug))
+	}
+	if errors.Is(err, ErrKeyNotFound) {
+		return Link{}, fmt.Errorf("Could not resolve link %s: %w", slug, ErrLinkNotFound)
+	} else if err != nil {
+		return Link{}, fmt.Errorf("Could not resolve link %s: %w", slug, err)
+	}
+	return db.GetLink(ctx, linkSlug)
+}
+
+// Aliases, and slugs folded to lowercase, point at the slug of the link they belong to.
+// Slugs can't contain colons, so these never collide with links.
+func aliasKey(alias string) string {
+	return "alias:" + alias
+}
+
+func foldKey(slug string) string {
+	return "fold:" + strings.ToLower(slug)
+}
+
+// slugKeys are the keys to watch while giving a slug to a link
+func slugKeys(slug string) []string {
+	return []string{slug, aliasKey(slug), foldKey(slug), trashKey(slug)}
+}
+
+// checkSlugFree makes sure a slug can be given to the link at owner, which is empty for new links.
+// Slugs and aliases of other links are taken, as are their case variants when slugs are case-insensitive.
+// Links in the trash keep their slugs, so they can always be restored.
+func checkSlugFree(ctx context.Context, tx Tx, slug string, owner string) error {
+	for _, key := range []string{slug, aliasKey(slug), trashKey(slug)} {
+		exists, err := tx.Exists(ctx, key)
+		if err != nil {
+			return fmt.Errorf("Could not check if %s exists: %w", slug, err)
+		}
+		if exists {
+			return ErrLinkExists
+		}
+	}
+	if caseInsensitiveSlugs {
+		folded, err := getFold(ctx, tx, slug)
+		if err != nil {
+			return err
+		}
+		if folded != "" && folded != owner {
+			return ErrLinkExists
+		}
+	}
+	return nil
+}
+
+// IsSlugFree reports whether a new link could be created at slug right now
+func (db DB) IsSlugFree(ctx context.Context, slug string) (bool, error) {
+	free := true
+	err := db.basicDB.Transaction(ctx, slugKeys(slug), func(tx Tx) error {
+		err := checkSlugFree(ctx, tx, slug, "")
+		if errors.Is(err, ErrLinkExists) {
+			free = false
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		return false, fmt.Errorf("Could not check if %s is free: %w", slug, err)
+	}
+	return free, nil
+}
+
+// getFold is the slug of the link a slug's case variants lead to, empty if none do
+func getFold(ctx context.Context, tx Tx, slug string) (string, error) {
+	folded, err := tx.Get(ctx, foldKey(slug))
+	if errors.Is(err, ErrKeyNotFound) {
+		return "", nil
+	} else if err != nil {
+		return "", fmt.Errorf("Could not get case variants of %s: %w", slug, err)
+	}
+	return folded, nil
+}
+
+// claimFold points a slug's case variants at owner, unless another link already has them
+func claimFold(ctx context.Context, tx Tx, slug string, owner string) error {
+	folded, err := getFold(ctx, tx, slug)
+	if err != nil {
+		return err
+	}
+	if folded == "" {
+		tx.Set(foldKey(slug), owner, 0)
+	}
+	return nil
+}
+
+// releaseFold stops a slug's case variants leading to owner, unless one of kept, the slugs and aliases
+// owner still has, shares them
+func releaseFold(ctx context.Context, tx Tx, slug string, owner string, kept []string) error {
+	if slices.ContainsFunc(kept, func(other string) bool { return foldKey(other) == foldKey(slug) }) {
+		return nil
+	}
+	folded, err := getFold(ctx, tx, slug)
+	if err != nil {
+		return err
+	}
+	if folded == owner {
+		tx.Delete(foldKey(slug))
+	}
+	return nil
+}
+
+// parseStringList reads a list stored in a hash field, like a link's aliases or tags
+func parseStringList(rawList string) ([]string, error) {
+	list := []string{}
+	if rawList == "" {
+		return list, nil
+	}
+	err := json.Unmarshal([]byte(rawList), &list)
+	if err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func formatStringList(list []string) string {
+	if len(list) == 0 {
+		return ""
+	}
+	rawList, _ := json.Marshal(list) // Strings always encode
+	return string(rawList)
+}
+
+// AddAlias gives a link another slug, sharing its destination and clicks
+func (db DB) AddAlias(ctx context.Context, linkSlug string, alias string) error {
+	return db.basicDB.Transaction(ctx, append(slugKeys(alias), linkSlug), func(tx Tx) error {
+		rawLink, err := tx.GetHash(ctx, linkSlug)
+		if err != nil {
+			return fmt.Errorf("Could not get link %s: %w", linkSlug, err)
+		}
+		if len(rawLink) == 0 {
+			return fmt.Errorf("Could not add alias %s to link %s: %w", alias, linkSlug, ErrLinkNotFound)
+		}
+		link, err := linkFromHash(linkSlug, rawLink)
+		if err != nil {
+			return fmt.Errorf("Could not add alias %s to link %s: %w", alias, linkSlug, err)
+		}
+		err = checkSlugFree(ctx, tx, alias, linkSlug)
+		if err != nil {
+			return fmt.Errorf("Could not add alias %s to link %s: %w", alias, linkSlug, err)
+		}
+
+		oldKeys := link.searchKeys()
+		link.Aliases = append(link.Aliases, alias)
+		tx.Set(aliasKey(alias), linkSlug, 0)
+		tx.SetHash(linkSlug, map[string]string{"aliases": formatStringList(link.Aliases), "updated_at": db.now()})
+		indexSearch(tx, linkSlug, oldKeys, link.searchKeys())
+		return claimFold(ctx, tx, alias, linkSlug)
+	})
+}
+
+// RemoveAlias takes an alias away from a link, leaving the link and its other aliases alone
+func (db DB) RemoveAlias(ctx context.Context, linkSlug string, alias string) error {
+	return db.basicDB.Transaction(ctx, append(slugKeys(alias), linkSlug), func(tx Tx) error {
+		rawLink, err := tx.GetHash(ctx, linkSlug)
+		if err != nil {
+			return fmt.Errorf("Could not get link %s: %w", linkSlug, err)
+		}
+		link, err := linkFromHash(linkSlug, rawLink)
+		if err != nil {
+			return fmt.Errorf("Could not remove alias %s from link %s: %w", alias, linkSlug, err)
+		}
+		index := slices.Index(link.Aliases, alias)
+		if index == -1 {
+			return fmt.Errorf("Could not remove alias %s from link %s: %w", alias, linkSlug, ErrLinkNotFound)
+		}
+
+		oldKeys := link.searchKeys()
+		link.Aliases = slices.Delete(link.Aliases, index, index+1)
+		tx.Delete(aliasKey(alias))
+		tx.SetHash(linkSlug, map[string]string{"aliases": formatStringList(link.Aliases), "updated_at": db.now()})
+		indexSearch(tx, linkSlug, oldKeys, link.searchKeys())
+		return releaseFold(ctx, tx, alias, linkSlug, append([]string{linkSlug}, link.Aliases...))
+	})
+}
+
+// linkFromHash turns a stored link hash back into a Link
+func linkFromHash(linkSlug string, rawLink map[string]string) (Link, error) {
+	if len(rawLink) == 0 { // HGETALL gives an empty hash for missing keys
+		return Link{}, fmt.Errorf("Could not get link %s: %w", linkSlug, ErrLinkNotFound)
 	}
 	clicks, err := strconv.Atoi(rawLink["clicks"])
 	if err != nil {
-		return Link{}, errors.New("Could not get clicks for link " + linkSlug + ": " + err.Error())
+		return Link{}, fmt.Errorf("Could not get clicks for link %s: %w", linkSlug, err)
 	}
 
-	var lastClick *time.Time
-	if lastClickStr, exists := rawLink["last_click"]; exists && lastClickStr != "" {
-		parsed, err := time.Parse(time.RFC3339, lastClickStr)
+	lastClick, err := parseOptionalTime(rawLink["last_click"])
+	if err != nil {
+		return Link{}, fmt.Errorf("Could not parse last_click for link %s: %w", linkSlug, err)
+	}
+	expiresAt, err := parseOptionalTime(rawLink["expires_at"])
+	if err != nil {
+		return Link{}, fmt.Errorf("Could not parse expires_at for link %s: %w", linkSlug, err)
+	}
+	maxClicks, err := parseOptionalInt(rawLink["max_clicks"])
+	if err != nil {
+		return Link{}, fmt.Errorf("Could not parse max_clicks for link %s: %w", linkSlug, err)
+	}
+	notBefore, err := parseOptionalTime(rawLink["not_before"])
+	if err != nil {
+		return Link{}, fmt.Errorf("Could not parse not_before for link %s: %w", linkSlug, err)
+	}
+	redirectCode, err := parseRedirectCode(rawLink["redirect_code"])
+	if err != nil {
+		return Link{}, fmt.Errorf("Could not parse redirect_code for link %s: %w", linkSlug, err)
+	}
+	kind, err := parseLinkKind(rawLink["kind"])
+	if err != nil {
+		return Link{}, fmt.Errorf("Could not parse kind for link %s: %w", linkSlug, err)
+	}
+	queryMerge, err := parseQueryMerge(rawLink["query_merge"])
+	if err != nil {
+		return Link{}, fmt.Errorf("Could not parse query_merge for link %s: %w", linkSlug, err)
+	}
+	aliases, err := parseStringList(rawLink["aliases"])
+	if err != nil {
+		return Link{}, fmt.Errorf("Could not parse aliases for link %s: %w", linkSlug, err)
+	}
+	tags, err := parseStringList(rawLink["tags"])
+	if err != nil {
+		return Link{}, fmt.Errorf("Could not parse tags for link %s: %w", linkSlug, err)
+	}
+	createdAt, err := parseOptionalTime(rawLink["created_at"])
+	if err != nil {
+		return Link{}, fmt.Errorf("Could not parse created_at for link %s: %w", linkSlug, err)
+	}
+	updatedAt, err := parseOptionalTime(rawLink["updated_at"])
+	if err != nil {
+		return Link{}, fmt.Errorf("Could not parse updated_at for link %s: %w", linkSlug, err)
+	}
+	var schedule []ScheduledURL
+	if rawSchedule := rawLink["schedule"]; rawSchedule != "" {
+		err = json.Unmarshal([]byte(rawSchedule), &schedule)
 		if err != nil {
-			return Link{}, errors.New("Could not parse last_click for link " + linkSlug + ": " + err.Error())
+			return Link{}, fmt.Errorf("Could not parse schedule for link %s: %w", linkSlug, err)
 		}
-		lastClick = &parsed
 	}
 
 	link := Link{
 		Slug:      linkSlug,
+		Title:     rawLink["title"],
+		Notes:     rawLink["notes"],
+		Aliases:   aliases,
+		Tags:      tags,
+		Kind:      kind,
 		URL:       rawLink["url"],
 		Clicks:    clicks,
 		LastClick: lastClick,
+		ExpiresAt: expiresAt,
+		MaxClicks: maxClicks,
+		NotBefore: notBefore,
+		Schedule:  schedule,
+
+		RedirectCode: redirectCode,
+		Passthrough:  rawLink["passthrough"] == "true",
+		QueryMerge:   queryMerge,
+		PasswordHash: rawLink["password_hash"],
+		Disabled:     rawLink["disabled"] == "true",
+		FallbackURL:  rawLink["fallback_url"],
+		CreatedBy:    rawLink["created_by"],
+		CreatedAt:    createdAt,
+		UpdatedAt:    updatedAt,
 	}
 	return link, nil
 }
 
+// parseOptionalInt reads a stored number, where an empty string means zero
+func parseOptionalInt(raw string) (int, error) {
+	if raw == "" {
+		return 0, nil
+	}
+	return strconv.Atoi(raw)
+}
+
+// parseOptionalTime reads a stored RFC3339 timestamp, where an empty string means no time was set
+func parseOptionalTime(raw string) (*time.Time, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	parsed, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		return nil, err
+	}
+	return &parsed, nil
+}
+
+// formatOptionalTime is the inverse of parseOptionalTime
+func formatOptionalTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
 func (db DB) SetLink(ctx context.Context, link Link) error {
-	exists, err := db.basicDB.Exists(ctx, link.Slug)
+	return db.basicDB.Transaction(ctx, slugKeys(link.Slug), func(tx Tx) error {
+		err := checkSlugFree(ctx, tx, link.Slug, "")
+		if err != nil {
+			return fmt.Errorf("Could not set link %s: %w", link.Slug, err)
+		}
+
+		if link.CreatedAt == nil {
+			now := db.timeFunc()
+			link.CreatedAt = &now
+		}
+		if link.UpdatedAt == nil {
+			link.UpdatedAt = link.CreatedAt
+		}
+		rawLink := link.settingsHash()
+		rawLink["clicks"] = strconv.Itoa(link.Clicks)
+		rawLink["disabled"] = strconv.FormatBool(link.Disabled)
+		rawLink["created_by"] = link.CreatedBy
+		rawLink["created_at"] = formatOptionalTime(link.CreatedAt)
+		rawLink["updated_at"] = formatOptionalTime(link.UpdatedAt)
+		tx.SetHash(link.Slug, rawLink)
+		tx.AddToList("links", link.Slug)
+		recordHistory(tx, link.Slug, HistoryEntry{At: *link.CreatedAt, Actor: link.CreatedBy, Action: HistoryCreated, Slug: link.Slug, URL: link.URL})
+		indexTags(tx, link.Slug, nil, link.Tags)
+		indexSearch(tx, link.Slug, nil, link.searchKeys())
+		return claimFold(ctx, tx, link.Slug, link.Slug)
+	})
+}
+
+// UpdateLink changes the settings of an existing link, renaming it if newSlug is different.
+// change edits the stored link inside the transaction, so settings it leaves alone are kept even if they changed since the caller read the link.
+// Analytics like clicks and last click are kept as they are, and renames, new destinations and disabling are added to its history.
+func (db DB) UpdateLink(ctx context.Context, linkSlug string, newSlug string, actor string, change func(link *Link) error) error {
+	watchKeys := []string{linkSlug}
+	renaming := newSlug != linkSlug
+	if renaming {
+		watchKeys = append(watchKeys, foldKey(linkSlug), "links", historyKey(linkSlug)) // Watch the list so the slug's index stays put
+		watchKeys = append(watchKeys, slugKeys(newSlug)...)
+	}
+	var droppedTags []string
+	err := db.basicDB.Transaction(ctx, watchKeys, func(tx Tx) error {
+		droppedTags = nil
+		rawLink, err := tx.GetHash(ctx, linkSlug)
+		if err != nil {
+			return fmt.Errorf("Could not get link %s: %w", linkSlug, err)
+		}
+		if len(rawLink) == 0 {
+			return fmt.Errorf("Could not update link %s: %w", linkSlug, ErrLinkNotFound)
+		}
+		oldLink, err := linkFromHash(linkSlug, rawLink)
+		if err != nil {
+			return fmt.Errorf("Could not update link %s: %w", linkSlug, err)
+		}
+		link := oldLink
+		err = change(&link)
+		if err != nil {
+			return err
+		}
+		link.Slug = newSlug
+		link.Aliases = oldLink.Aliases // Aliases are changed on their own, so only the stored ones are indexed
+		oldTags, oldKeys := oldLink.Tags, oldLink.searchKeys()
+		now := db.timeFunc()
+
+		if renaming {
+			err = checkSlugFree(ctx, tx, link.Slug, linkSlug)
+			if err != nil {
+				return fmt.Errorf("Could not rename link %s to %s: %w", linkSlug, link.Slug, err)
+			}
+			linkSlugs, err := tx.GetList(ctx, "links")
+			if err != nil {
+				return fmt.Errorf("Could not get link slugs: %w", err)
+			}
+			err = moveLinkPointers(ctx, tx, linkSlug, link.Slug)
+			if err != nil {
+				return err
+			}
+
+			tx.Rename(linkSlug, link.Slug)
+			if index := slices.Index(linkSlugs, linkSlug); index != -1 {
+				tx.SetListItem("links", index, link.Slug)
+			} else { // Shouldn't happen, but don't leave the renamed link unlisted
+				tx.AddToList("links", link.Slug)
+			}
+			droppedTags = indexTags(tx, linkSlug, oldTags, nil)
+			indexSearch(tx, linkSlug, oldKeys, nil)
+			oldTags, oldKeys = nil, nil
+
+			hasHistory, err := tx.Exists(ctx, historyKey(linkSlug))
+			if err != nil {
+				return fmt.Errorf("Could not check history of link %s: %w", linkSlug, err)
+			}
+			if hasHistory { // Links made before history was kept don't have any
+				tx.Rename(historyKey(linkSlug), historyKey(link.Slug))
+			}
+			recordHistory(tx, link.Slug, HistoryEntry{At: now, Actor: actor, Action: HistoryRenamed, Slug: link.Slug, OldSlug: linkSlug})
+		}
+		if link.URL != oldLink.URL {
+			recordHistory(tx, link.Slug, HistoryEntry{At: now, Actor: actor, Action: HistoryURLChanged, Slug: link.Slug, URL: link.URL, OldURL: oldLink.URL})
+		}
+		rawLink = link.settingsHash()
+		rawLink["updated_at"] = formatOptionalTime(&now)
+		if link.Disabled != oldLink.Disabled {
+			rawLink["disabled"] = strconv.FormatBool(link.Disabled)
+			recordHistory(tx, link.Slug, HistoryEntry{At: now, Actor: actor, Action: disabledAction(link.Disabled), Slug: link.Slug})
+		}
+		tx.SetHash(link.Slug, rawLink)
+		droppedTags = append(droppedTags, indexTags(tx, link.Slug, oldTags, link.Tags)...)
+		indexSearch(tx, link.Slug, oldKeys, link.searchKeys())
+		return nil
+	})
 	if err != nil {
-		return errors.New("Could not check if link " + link.Slug + " exists: " + err.Error())
+		return err
 	}
-	if exists {
-		return errors.New("Link " + link.Slug + " already exists")
+	db.pruneTags(ctx, droppedTags)
+	return nil
+}
+
+// UpdateLinksTags adds and removes tags on several links at once, leaving their other tags and settings alone.
+// Either every link is tagged or, if one of them is missing or would have too many tags, none are.
+func (db DB) UpdateLinksTags(ctx context.Context, linkSlugs []string, addTags []string, removeTags []string) ([]Link, error) {
+	linkSlugs = uniqueStrings(linkSlugs) // Reads in a transaction don't see its own writes
+	var links []Link
+	var droppedTags []string
+	err := db.basicDB.Transaction(ctx, linkSlugs, func(tx Tx) error {
+		links, droppedTags = []Link{}, nil
+		for _, linkSlug := range linkSlugs {
+			rawLink, err := tx.GetHash(ctx, linkSlug)
+			if err != nil {
+				return fmt.Errorf("Could not get link %s: %w", linkSlug, err)
+			}
+			link, err := linkFromHash(linkSlug, rawLink)
+			if err != nil {
+				return fmt.Errorf("Could not tag link %s: %w", linkSlug, err)
+			}
+
+			tags := slices.DeleteFunc(slices.Clone(link.Tags), func(tag string) bool {
+				return slices.Contains(removeTags, tag)
+			})
+			for _, tag := range addTags {
+				if !slices.Contains(tags, tag) {
+					tags = append(tags, tag)
+				}
+			}
+			if len(tags) > maxTagsPerLink {
+				return fmt.Errorf("Could not tag link %s: %w", linkSlug, ErrTooManyTags)
+			}
+
+			now := db.timeFunc()
+			tx.SetHash(linkSlug, map[string]string{"tags": formatStringList(tags), "updated_at": formatOptionalTime(&now)})
+			droppedTags = append(droppedTags, indexTags(tx, linkSlug, link.Tags, tags)...)
+			oldKeys := link.searchKeys()
+			link.Tags, link.UpdatedAt = tags, &now
+			indexSearch(tx, linkSlug, oldKeys, link.searchKeys())
+			links = append(links, link)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
+	db.pruneTags(ctx, droppedTags)
+	return links, nil
+}
 
-	err = db.basicDB.SetHash(ctx, link.Slug, map[string]string{
-		"url":    link.URL,
-		"clicks": strconv.Itoa(link.Clicks),
+// SetLinksDisabled stops links redirecting, or lets them redirect again, without touching anything else about them.
+// Either every link is changed or, if one of them is missing, none are.
+func (db DB) SetLinksDisabled(ctx context.Context, linkSlugs []string, disabled bool, actor string) ([]Link, error) {
+	linkSlugs = uniqueStrings(linkSlugs) // Reads in a transaction don't see its own writes
+	var links []Link
+	err := db.basicDB.Transaction(ctx, linkSlugs, func(tx Tx) error {
+		links = []Link{}
+		for _, linkSlug := range linkSlugs {
+			rawLink, err := tx.GetHash(ctx, linkSlug)
+			if err != nil {
+				return fmt.Errorf("Could not get link %s: %w", linkSlug, err)
+			}
+			link, err := linkFromHash(linkSlug, rawLink)
+			if err != nil {
+				return fmt.Errorf("Could not change link %s: %w", linkSlug, err)
+			}
+			if link.Disabled != disabled {
+				now := db.timeFunc()
+				tx.SetHash(linkSlug, map[string]string{"disabled": strconv.FormatBool(disabled), "updated_at": formatOptionalTime(&now)})
+				recordHistory(tx, linkSlug, HistoryEntry{At: now, Actor: actor, Action: disabledAction(disabled), Slug: linkSlug})
+				link.Disabled, link.UpdatedAt = disabled, &now
+			}
+			links = append(links, link)
+		}
+		return nil
 	})
 	if err != nil {
-		return errors.New("Could not set link " + link.Slug + ": " + err.Error())
+		return nil, err
 	}
-	err = db.basicDB.AddToList(ctx, "links", link.Slug)
+	return links, nil
+}
+
+// RollbackLink points a link back at a destination from its history
+func (db DB) RollbackLink(ctx context.Context, linkSlug string, url string, actor string) error {
+	return db.basicDB.Transaction(ctx, []string{linkSlug, historyKey(linkSlug)}, func(tx Tx) error {
+		rawLink, err := tx.GetHash(ctx, linkSlug)
+		if err != nil {
+			return fmt.Errorf("Could not get link %s: %w", linkSlug, err)
+		}
+		link, err := linkFromHash(linkSlug, rawLink)
+		if err != nil {
+			return fmt.Errorf("Could not roll back link %s: %w", linkSlug, err)
+		}
+		rawEntries, err := tx.GetList(ctx, historyKey(linkSlug))
+		if err != nil {
+			return fmt.Errorf("Could not get history of link %s: %w", linkSlug, err)
+		}
+		if !hadDestination(parseHistory(linkSlug, rawEntries), url) {
+			return fmt.Errorf("Could not roll back link %s to %s: %w", linkSlug, url, ErrUnknownDestination)
+		}
+		if url == link.URL {
+			return nil
+		}
+
+		oldKeys := link.searchKeys()
+		now := db.timeFunc()
+		tx.SetHash(linkSlug, map[string]string{"url": url, "updated_at": formatOptionalTime(&now)})
+		recordHistory(tx, linkSlug, HistoryEntry{At: now, Actor: actor, Action: HistoryRolledBack, Slug: linkSlug, URL: url, OldURL: link.URL})
+		link.URL = url
+		indexSearch(tx, linkSlug, oldKeys, link.searchKeys())
+		return nil
+	})
+}
+
+// GetLinkHistory lists the changes to a link, newest first. Links in the trash keep their history until they're purged.
+func (db DB) GetLinkHistory(ctx context.Context, linkSlug string) ([]HistoryEntry, error) {
+	rawEntries, err := db.basicDB.GetList(ctx, historyKey(linkSlug))
 	if err != nil {
-		return errors.New("Could not add link " + link.Slug + " to links list: " + err.Error())
+		return nil, fmt.Errorf("Could not get history of link %s: %w", linkSlug, err)
 	}
-	return nil
+	if len(rawEntries) == 0 { // Either the link has no history yet, or there's no link
+		exists, err := db.basicDB.Exists(ctx, linkSlug)
+		if err != nil {
+			return nil, fmt.Errorf("Could not check if link %s exists: %w", linkSlug, err)
+		}
+		trashed, err := db.basicDB.Exists(ctx, trashKey(linkSlug))
+		if err != nil {
+			return nil, fmt.Errorf("Could not check if link %s is in the trash: %w", linkSlug, err)
+		}
+		if !exists && !trashed {
+			return nil, fmt.Errorf("Could not get history of link %s: %w", linkSlug, ErrLinkNotFound)
+		}
+	}
+	return parseHistory(linkSlug, rawEntries), nil
+}
+
+// The reverse index of tags. Each tag has a set of the slugs tagged with it,
+// and every tag that has been used is in the allTagsKey set.
+const allTagsKey = "tags"
+
+func tagKey(tag string) string {
+	return "tag:" + tag
+}
+
+// indexTags moves a link from the sets of the tags it had to the sets of the tags it has.
+// It returns the tags the link was taken out of, which pruneTags should check once the change is saved.
+func indexTags(tx Tx, linkSlug string, oldTags []string, newTags []string) []string {
+	dropped := []string{}
+	for _, tag := range oldTags {
+		if !slices.Contains(newTags, tag) {
+			tx.RemoveFromSet(tagKey(tag), linkSlug)
+			dropped = append(dropped, tag)
+		}
+	}
+	for _, tag := range newTags {
+		if !slices.Contains(oldTags, tag) {
+			tx.AddToSet(tagKey(tag), linkSlug)
+			tx.AddToSet(allTagsKey, tag)
+		}
+	}
+	return dropped
+}
+
+// tagMembers maps every tag, and every folder of tags, to the slugs of the links in it
+func (db DB) tagMembers(ctx context.Context) (map[string]map[string]bool, error) {
+	tags, err := db.basicDB.GetSet(ctx, allTagsKey)
+	if err != nil {
+		return nil, fmt.Errorf("Could not get tags: %w", err)
+	}
+	keys := make([]string, len(tags))
+	for i, tag := range tags {
+		keys[i] = tagKey(tag)
+	}
+	tagged, err := db.basicDB.GetSets(ctx, keys)
+	if err != nil {
+		return nil, fmt.Errorf("Could not get tagged links: %w", err)
+	}
+
+	members := map[string]map[string]bool{}
+	for i, tag := range tags {
+		if len(tagged[i]) == 0 { // Left behind by a change that couldn't prune it
+			continue
+		}
+		for _, folder := range tagFolders(tag) {
+			if members[folder] == nil {
+				members[folder] = map[string]bool{}
+			}
+			for _, linkSlug := range tagged[i] {
+				members[folder][linkSlug] = true
+			}
+		}
+	}
+	return members, nil
+}
+
+// pruneTags drops tags without any links left from the list of tags, unless a link was given them in the meantime.
+// It runs after the change that emptied them, so failing only leaves an unused tag behind.
+func (db DB) pruneTags(ctx context.Context, tags []string) {
+	if len(tags) == 0 {
+		return
+	}
+	keys := make([]string, len(tags))
+	for i, tag := range tags {
+		keys[i] = tagKey(tag)
+	}
+	err := db.basicDB.Transaction(ctx, keys, func(tx Tx) error {
+		for _, tag := range tags {
+			linkSlugs, err := tx.GetSet(ctx, tagKey(tag))
+			if err != nil {
+				return fmt.Errorf("Could not get links tagged %s: %w", tag, err)
+			}
+			if len(linkSlugs) == 0 {
+				tx.RemoveFromSet(allTagsKey, tag)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		logging.PrintErrStr("Could not prune unused tags: " + err.Error())
+	}
+}
+
+// GetTags lists every tag and folder of tags in use, with how many links are in each
+func (db DB) GetTags(ctx context.Context) ([]TagCount, error) {
+	members, err := db.tagMembers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return countTags(members), nil
+}
+
+// countTags lists tags with how many links are in each, sorted the way they show in the sidebar
+func countTags(members map[string]map[string]bool) []TagCount {
+	tags := make([]TagCount, 0, len(members))
+	for tag, linkSlugs := range members {
+		tags = append(tags, TagCount{Name: tag, Count: len(linkSlugs)})
+	}
+	slices.SortFunc(tags, func(a TagCount, b TagCount) int {
+		return compareTags(a.Name, b.Name)
+	})
+	return tags
 }
 
-func (db DB) DeleteLink(ctx context.Context, linkSlug string) error {
-	err := db.basicDB.DeleteHash(ctx, linkSlug)
+// moveLinkPointers points the aliases and case variants of a renamed link at its new slug
+func moveLinkPointers(ctx context.Context, tx Tx, oldSlug string, newSlug string) error {
+	rawLink, err := tx.GetHash(ctx, oldSlug)
 	if err != nil {
-		return errors.New("Could not delete link " + linkSlug + ": " + err.Error())
+		return fmt.Errorf("Could not get link %s: %w", oldSlug, err)
 	}
-	err = db.basicDB.RemoveFromList(ctx, "links", linkSlug)
+	aliases, err := parseStringList(rawLink["aliases"])
 	if err != nil {
-		return errors.New("Could not remove link " + linkSlug + " from links list: " + err.Error())
+		return fmt.Errorf("Could not parse aliases for link %s: %w", oldSlug, err)
+	}
+	for _, alias := range aliases {
+		tx.Set(aliasKey(alias), newSlug, 0)
+		folded, err := getFold(ctx, tx, alias)
+		if err != nil {
+			return err
+		}
+		if folded == oldSlug {
+			tx.Set(foldKey(alias), newSlug, 0)
+		}
 	}
+
+	folded, err := getFold(ctx, tx, oldSlug)
+	if err != nil {
+		return err
+	}
+	if strings.EqualFold(oldSlug, newSlug) { // Only the case changed, so both slugs share case variants
+		if folded == "" || folded == oldSlug {
+			tx.Set(foldKey(newSlug), newSlug, 0)
+		}
+		return nil
+	}
+	err = releaseFold(ctx, tx, oldSlug, oldSlug, aliases) // An alias differing only by case keeps them, now leading to newSlug
+	if err != nil {
+		return err
+	}
+	return claimFold(ctx, tx, newSlug, newSlug)
+}
+
+// now is the current time in the form links store it
+func (db DB) now() string {
+	now := db.timeFunc()
+	return formatOptionalTime(&now)
+}
+
+// settingsHash holds the stored fields of a link that users choose, leaving out analytics
+func (link Link) settingsHash() map[string]string {
+	schedule := ""
+	if len(link.Schedule) > 0 {
+		rawSchedule, _ := json.Marshal(link.Schedule) // Times and strings always encode
+		schedule = string(rawSchedule)
+	}
+	return map[string]string{
+		"title":      link.Title,
+		"notes":      link.Notes,
+		"kind":       string(link.Kind),
+		"url":        link.URL,
+		"expires_at": formatOptionalTime(link.ExpiresAt),
+		"max_clicks": strconv.Itoa(link.MaxClicks),
+		"not_before": formatOptionalTime(link.NotBefore),
+		"schedule":   schedule,
+
+		"redirect_code": strconv.Itoa(link.RedirectCode),
+		"passthrough":   strconv.FormatBool(link.Passthrough),
+		"query_merge":   string(link.QueryMerge),
+		"password_hash": link.PasswordHash,
+		"fallback_url":  link.FallbackURL,
+		"tags":          formatStringList(link.Tags),
+	}
+}
+
+// TrashLink moves a link into the trash, where it stops redirecting until it's restored or purged.
+// Its aliases and case variants stay pointed at its slug, so nothing else can take them in the meantime.
+func (db DB) TrashLink(ctx context.Context, linkSlug string, actor string) error {
+	var droppedTags []string
+	err := db.basicDB.Transaction(ctx, []string{linkSlug, trashKey(linkSlug)}, func(tx Tx) error {
+		exists, err := tx.Exists(ctx, linkSlug)
+		if err != nil {
+			return fmt.Errorf("Could not check if link %s exists: %w", linkSlug, err)
+		}
+		if !exists {
+			return fmt.Errorf("Could not delete link %s: %w", linkSlug, ErrLinkNotFound)
+		}
+		rawLink, err := tx.GetHash(ctx, linkSlug)
+		if err != nil {
+			return fmt.Errorf("Could not get link %s: %w", linkSlug, err)
+		}
+		tags, err := parseStringList(rawLink["tags"])
+		if err != nil {
+			return fmt.Errorf("Could not parse tags for link %s: %w", linkSlug, err)
+		}
+
+		droppedTags = indexTags(tx, linkSlug, tags, nil)
+		// Searches double check their results, so a link too broken to read can leave its index entries behind
+		if link, err := linkFromHash(linkSlug, rawLink); err == nil {
+			indexSearch(tx, linkSlug, link.searchKeys(), nil)
+		}
+		now := db.timeFunc()
+		tx.Rename(linkSlug, trashKey(linkSlug))
+		tx.SetHash(trashKey(linkSlug), map[string]string{"deleted_at": formatOptionalTime(&now)})
+		recordHistory(tx, linkSlug, HistoryEntry{At: now, Actor: actor, Action: HistoryDeleted, Slug: linkSlug})
+		tx.RemoveFromList("links", linkSlug)
+		tx.AddToSet(trashedLinksKey, linkSlug)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	db.pruneTags(ctx, droppedTags) // Restoring the link adds its tags back
 	return nil
 }
 
+// RestoreLink takes a link back out of the trash, as it was when it was deleted.
+// It goes back to the top of the links list, like a newly created link.
+func (db DB) RestoreLink(ctx context.Context, linkSlug string, actor string) (Link, error) {
+	var link Link
+	err := db.basicDB.Transaction(ctx, []string{linkSlug, trashKey(linkSlug)}, func(tx Tx) error {
+		rawLink, err := tx.GetHash(ctx, trashKey(linkSlug))
+		if err != nil {
+			return fmt.Errorf("Could not get trashed link %s: %w", linkSlug, err)
+		}
+		link, err = linkFromHash(linkSlug, rawLink)
+		if err != nil {
+			return fmt.Errorf("Could not restore link %s: %w", linkSlug, err)
+		}
+		exists, err := tx.Exists(ctx, linkSlug)
+		if err != nil {
+			return fmt.Errorf("Could not check if link %s exists: %w", linkSlug, err)
+		}
+		if exists { // Only possible for links trashed before their slugs were kept reserved
+			return fmt.Errorf("Could not restore link %s: %w", linkSlug, ErrLinkExists)
+		}
+
+		tx.Rename(trashKey(linkSlug), linkSlug)
+		tx.SetHash(linkSlug, map[string]string{"deleted_at": ""})
+		tx.AddToList("links", linkSlug)
+		tx.RemoveFromSet(trashedLinksKey, linkSlug)
+		recordHistory(tx, linkSlug, HistoryEntry{At: db.timeFunc(), Actor: actor, Action: HistoryRestored, Slug: linkSlug})
+		indexTags(tx, linkSlug, nil, link.Tags)
+		indexSearch(tx, linkSlug, nil, link.searchKeys())
+		return nil
+	})
+	if err != nil {
+		return Link{}, err
+	}
+	return link, nil
+}
+
+// PurgeLink permanently deletes a link and its history from the trash, freeing its slug and aliases
+func (db DB) PurgeLink(ctx context.Context, linkSlug string) error {
+	return db.basicDB.Transaction(ctx, []string{trashKey(linkSlug), foldKey(linkSlug)}, func(tx Tx) error {
+		rawLink, err := tx.GetHash(ctx, trashKey(linkSlug))
+		if err != nil {
+			return fmt.Errorf("Could not get trashed link %s: %w", linkSlug, err)
+		}
+		if len(rawLink) == 0 {
+			return fmt.Errorf("Could not purge link %s: %w", linkSlug, ErrLinkNotFound)
+		}
+		aliases, err := parseStringList(rawLink["aliases"])
+		if err != nil {
+			return fmt.Errorf("Could not parse aliases for link %s: %w", linkSlug, err)
+		}
+
+		for _, alias := range aliases {
+			tx.Delete(aliasKey(alias))
+			err = releaseFold(ctx, tx, alias, linkSlug, nil)
+			if err != nil {
+				return err
+			}
+		}
+		tx.Delete(trashKey(linkSlug))
+		tx.Delete(historyKey(linkSlug))
+		tx.RemoveFromSet(trashedLinksKey, linkSlug)
+		return releaseFold(ctx, tx, linkSlug, linkSlug, nil)
+	})
+}
+
+// GetTrash lists the links in the trash, most recently deleted first
+func (db DB) GetTrash(ctx context.Context, retention time.Duration) ([]TrashedLink, error) {
+	linkSlugs, err := db.basicDB.GetSet(ctx, trashedLinksKey)
+	if err != nil {
+		return nil, fmt.Errorf("Could not get trashed links: %w", err)
+	}
+	trashed := make([]TrashedLink, 0, len(linkSlugs))
+	for batch := range slices.Chunk(linkSlugs, linkBatchSize) {
+		keys := make([]string, len(batch))
+		for i, linkSlug := range batch {
+			keys[i] = trashKey(linkSlug)
+		}
+		rawLinks, err := db.basicDB.GetHashes(ctx, keys)
+		if err != nil {
+			return nil, fmt.Errorf("Could not get trashed links: %w", err)
+		}
+		for i, rawLink := range rawLinks {
+			link, err := linkFromHash(batch[i], rawLink)
+			if err != nil {
+				logging.PrintErrStr("Skipping broken trashed link " + batch[i] + ": " + err.Error())
+				continue
+			}
+			deletedAt, err := time.Parse(time.RFC3339, rawLink["deleted_at"])
+			if err != nil {
+				logging.PrintErrStr("Skipping trashed link " + batch[i] + " without a deletion time: " + err.Error())
+				continue
+			}
+			trashed = append(trashed, TrashedLink{Link: link, DeletedAt: deletedAt, PurgeAt: deletedAt.Add(retention)})
+		}
+	}
+	slices.SortFunc(trashed, func(a TrashedLink, b TrashedLink) int {
+		return b.DeletedAt.Compare(a.DeletedAt)
+	})
+	return trashed, nil
+}
+
+// PurgeExpiredTrash permanently deletes links that have been in the trash for longer than retention, returning how many were purged
+func (db DB) PurgeExpiredTrash(ctx context.Context, retention time.Duration) (int, error) {
+	trashed, err := db.GetTrash(ctx, retention)
+	if err != nil {
+		return 0, err
+	}
+	now := db.timeFunc()
+	purged := 0
+	for _, link := range trashed {
+		if link.PurgeAt.After(now) {
+			continue
+		}
+		err = db.PurgeLink(ctx, link.Slug)
+		if errors.Is(err, ErrLinkNotFound) { // Restored or purged since the trash was read
+			continue
+		} else if err != nil {
+			return purged, err
+		}
+		purged++
+	}
+	return purged, nil
+}
+
 func (db DB) GetLinkSlugs(ctx context.Context) ([]string, error) {
 	slugs, err := db.basicDB.GetList(ctx, "links")
 	if err != nil {
-		return nil, errors.New("Could not get link slugs: " + err.Error())
+		return nil, fmt.Errorf("Could not get link slugs: %w", err)
 	}
 	return slugs, nil
 }
 
-func (db DB) GetLinks(ctx context.Context) ([]Link, error) {
-	linkSlugs, err := db.GetLinkSlugs(ctx)
+// How many links to fetch per pipelined round trip
+const linkBatchSize = 100
+
+func (db DB) GetLinks(ctx context.Context, query LinkQuery) (LinkPage, error) {
+	linkSlugs, err := db.GetLinkSlugs(ctx) // Newest first
+	if err != nil {
+		return LinkPage{}, fmt.Errorf("Could not get links: %w", err)
+	}
+	members, err := db.tagMembers(ctx)
 	if err != nil {
-		return nil, errors.New("Could not get links: " + err.Error())
+		return LinkPage{}, fmt.Errorf("Could not get links: %w", err)
 	}
-	links := make([]Link, len(linkSlugs))
-	for i, linkSlug := range linkSlugs {
-		link, err := db.GetLink(ctx, linkSlug)
+	if query.Search != "" {
+		matches, err := db.searchLinks(ctx, query.Search)
 		if err != nil {
-			logging.PrintErrStr("Failed to get link for " + linkSlug + ": " + err.Error())
-			continue
+			return LinkPage{}, fmt.Errorf("Could not get links: %w", err)
+		}
+		linkSlugs = slices.DeleteFunc(linkSlugs, func(linkSlug string) bool {
+			return !matches[linkSlug]
+		})
+	}
+	if len(query.Tags) > 0 { // Only links with every tag, or a tag in every folder
+		linkSlugs = slices.DeleteFunc(linkSlugs, func(linkSlug string) bool {
+			for _, tag := range query.Tags {
+				if !members[tag][linkSlug] {
+					return true
+				}
+			}
+			return false
+		})
+	}
+	page := LinkPage{Query: query, Tags: countTags(members), Now: db.timeFunc()}
+
+	// Every order needs every link before the page can be cut out, even creation order,
+	// since restored links go back to the top of the links list but keep when they were created
+	links, err := db.getLinksBySlug(ctx, linkSlugs)
+	if err != nil {
+		return LinkPage{}, err
+	}
+	page.Total = len(links)
+	sortLinks(links, query)
+	start, end := query.bounds(len(links))
+	page.Links = links[start:end]
+	return page, nil
+}
+
+// searchLinks finds the slugs of the links matching a search, using the index to avoid reading every link
+func (db DB) searchLinks(ctx context.Context, search string) (map[string]bool, error) {
+	terms := searchTerms(search)
+	var candidates []string
+	first := true
+	for _, term := range terms {
+		for _, key := range termKeys(term) {
+			linkSlugs, err := db.basicDB.GetSet(ctx, key)
+			if err != nil {
+				return nil, fmt.Errorf("Could not search for %s: %w", term, err)
+			}
+			if first {
+				candidates, first = linkSlugs, false
+			} else {
+				inSet := make(map[string]bool, len(linkSlugs))
+				for _, linkSlug := range linkSlugs {
+					inSet[linkSlug] = true
+				}
+				candidates = slices.DeleteFunc(candidates, func(linkSlug string) bool {
+					return !inSet[linkSlug]
+				})
+			}
+			if len(candidates) == 0 {
+				return map[string]bool{}, nil
+			}
+		}
+	}
+
+	links, err := db.getLinksBySlug(ctx, candidates)
+	if err != nil {
+		return nil, err
+	}
+	matches := map[string]bool{}
+	for _, link := range links {
+		if link.matchesSearch(terms) {
+			matches[link.Slug] = true
+		}
+	}
+	return matches, nil
+}
+
+// getLinksBySlug fetches links in pipelined batches, keeping their order and skipping any that are broken
+func (db DB) getLinksBySlug(ctx context.Context, linkSlugs []string) ([]Link, error) {
+	links := make([]Link, 0, len(linkSlugs))
+	for batch := range slices.Chunk(linkSlugs, linkBatchSize) {
+		rawLinks, err := db.basicDB.GetHashes(ctx, batch)
+		if err != nil {
+			return nil, fmt.Errorf("Could not get links: %w", err)
+		}
+		for i, rawLink := range rawLinks {
+			link, err := linkFromHash(batch[i], rawLink)
+			if err != nil {
+				logging.PrintErrStr("Skipping broken link " + batch[i] + ": " + err.Error())
+				continue
+			}
+			links = append(links, link)
 		}
-		links[i] = link
 	}
 	return links, nil
 }
@@ -316,7 +1573,7 @@ func (db DB) GetLinks(ctx context.Context) ([]Link, error) {
 func (db DB) GetJWTSecret(ctx context.Context) (string, error) {
 	jwt, err := db.basicDB.Get(ctx, "jwt")
 	if err != nil {
-		return "", errors.New("Could not get jwt: " + err.Error())
+		return "", fmt.Errorf("Could not get jwt: %w", err)
 	}
 	return jwt, nil
 }
@@ -324,7 +1581,7 @@ func (db DB) GetJWTSecret(ctx context.Context) (string, error) {
 func (db DB) SetJWTSecret(ctx context.Context, jwt string) error {
 	err := db.basicDB.Set(ctx, "jwt", jwt, 0)
 	if err != nil {
-		return errors.New("Could not set jwt: " + err.Error())
+		return fmt.Errorf("Could not set jwt: %w", err)
 	}
 	return nil
 }
@@ -332,7 +1589,7 @@ func (db DB) SetJWTSecret(ctx context.Context, jwt string) error {
 func (db DB) UserExists(ctx context.Context) (bool, error) {
 	exists, err := db.basicDB.Exists(ctx, "user-hash")
 	if err != nil {
-		return false, errors.New("Could not check if user exists: " + err.Error())
+		return false, fmt.Errorf("Could not check if user exists: %w", err)
 	}
 	return exists, nil
 }
@@ -340,7 +1597,7 @@ func (db DB) UserExists(ctx context.Context) (bool, error) {
 func (db DB) SetUser(ctx context.Context, passwordHash []byte) error {
 	err := db.basicDB.Set(ctx, "user-hash", string(passwordHash), 0)
 	if err != nil {
-		return errors.New("Could not set user: " + err.Error())
+		return fmt.Errorf("Could not set user: %w", err)
 	}
 	return nil
 }
@@ -348,26 +1605,37 @@ func (db DB) SetUser(ctx context.Context, passwordHash []byte) error {
 func (db DB) GetUser(ctx context.Context) (string, error) {
 	user, err := db.basicDB.Get(ctx, "user-hash")
 	if err != nil {
-		return "", errors.New("Could not get user: " + err.Error())
+		if errors.Is(err, ErrKeyNotFound) {
+			err = ErrUserNotFound
+		}
+		return "", fmt.Errorf("Could not get user: %w", err)
 	} else if user == "" {
-		return "", errors.New("User does not exist")
+		return "", fmt.Errorf("Could not get user: %w", ErrUserNotFound)
 	}
 	return user, nil
 }
 
+// LinkAnalytics records clicks on a link. If the clicks would take a link past its click limit,
+// nothing is recorded and ErrLinkUsedUp is returned, so concurrent visitors can never overshoot it.
 func (db DB) LinkAnalytics(ctx context.Context, linkSlug string, amount int, clickTime time.Time) error {
-	err := db.basicDB.IncrementHashField(ctx, linkSlug, "clicks", amount)
-	if err != nil {
-		return errors.New("Could not increment clicks for link " + linkSlug + ": " + err.Error())
-	}
+	return db.basicDB.Transaction(ctx, []string{linkSlug}, func(tx Tx) error {
+		rawLink, err := tx.GetHash(ctx, linkSlug)
+		if err != nil {
+			return fmt.Errorf("Could not get link %s: %w", linkSlug, err)
+		}
+		// Don't let a click racing a deletion bring back a link hash with no URL
+		link, err := linkFromHash(linkSlug, rawLink)
+		if err != nil {
+			return fmt.Errorf("Could not record click for link %s: %w", linkSlug, err)
+		}
+		if link.MaxClicks > 0 && link.Clicks+amount > link.MaxClicks {
+			return fmt.Errorf("Could not record click for link %s: %w", linkSlug, ErrLinkUsedUp)
+		}
 
-	// Update last click timestamp
-	err = db.basicDB.SetHash(ctx, linkSlug, map[string]string{
-		"last_click": clickTime.Format(time.RFC3339),
+		tx.IncrementHashField(linkSlug, "clicks", amount)
+		tx.SetHash(linkSlug, map[string]string{ // Update last click timestamp
+			"last_click": clickTime.Format(time.RFC3339),
+		})
+		return nil
 	})
-	if err != nil {
-		return errors.New("Could not update last_click for link " + linkSlug + ": " + err.Error())
-	}
-
-	return nil
 }
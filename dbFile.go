@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// fileEntry is the on-disk form of a memoryEntry
+type fileEntry struct {
+	Kind    memoryKind        `json:"kind"`
+	String  string            `json:"string,omitempty"`
+	Hash    map[string]string `json:"hash,omitempty"`
+	List    []string          `json:"list,omitempty"` // Also holds the members of sets
+	Expires *time.Time        `json:"expires,omitempty"`
+}
+
+// NewFileDB returns a MemoryDB that is loaded from, and saved back to, a single file.
+// Every change rewrites the whole file, which is fine for the few hundred links of a small install.
+// The search index is left out, since it's most of the data but can be rebuilt from the links, which SetupDB does on startup.
+func NewFileDB(path string, timeGenerator TimeFunc) (*MemoryDB, error) {
+	db := NewMemoryDB(timeGenerator)
+	db.path = path
+
+	err := os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return nil, errors.New("Could not create directory for database file " + path + ": " + err.Error())
+	}
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return db, nil
+	} else if err != nil {
+		return nil, errors.New("Could not read database file " + path + ": " + err.Error())
+	}
+
+	var saved map[string]fileEntry
+	err = json.Unmarshal(raw, &saved)
+	if err != nil {
+		return nil, errors.New("Could not parse database file " + path + ": " + err.Error())
+	}
+	for key, saved := range saved {
+		if !db.persisted(key) { // Saved by older versions
+			continue
+		}
+		entry := &memoryEntry{
+			kind: saved.Kind,
+			str:  saved.String,
+			hash: saved.Hash,
+			list: saved.List,
+		}
+		if entry.kind == memoryHash && entry.hash == nil {
+			entry.hash = map[string]string{}
+		}
+		if entry.kind == memorySet { // Sets are searched by binary search
+			slices.Sort(entry.list)
+			entry.list = slices.Compact(entry.list)
+		}
+		if saved.Expires != nil {
+			entry.expires = *saved.Expires
+		}
+		db.entries[key] = entry
+	}
+	return db, nil
+}
+
+// persisted reports whether a key is written to the database file
+func (db *MemoryDB) persisted(key string) bool {
+	return db.path != "" && !isSearchKey(key)
+}
+
+// save writes every live entry to the database file, if there is one.
+// The file is replaced atomically so a crash mid-write never leaves a truncated database behind.
+// Callers must hold db.mu.
+func (db *MemoryDB) save() error {
+	if db.path == "" {
+		return nil
+	}
+
+	snapshot := make(map[string]fileEntry, len(db.entries))
+	for key := range db.entries {
+		entry := db.entry(key)
+		if entry == nil || !db.persisted(key) { // Expired, or rebuilt on startup
+			continue
+		}
+		saved := fileEntry{
+			Kind:   entry.kind,
+			String: entry.str,
+			Hash:   entry.hash,
+			List:   entry.list,
+		}
+		if !entry.expires.IsZero() {
+			saved.Expires = &entry.expires
+		}
+		snapshot[key] = saved
+	}
+	raw, err := json.Marshal(snapshot)
+	if err != nil {
+		return errors.New("Could not encode database: " + err.Error())
+	}
+
+	tempPath := db.path + ".tmp"
+	file, err := os.OpenFile(tempPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return errors.New("Could not open database file " + tempPath + ": " + err.Error())
+	}
+	_, err = file.Write(raw)
+	if err == nil {
+		err = file.Sync()
+	}
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return errors.New("Could not write database file " + tempPath + ": " + err.Error())
+	}
+	err = os.Rename(tempPath, db.path)
+	if err != nil {
+		return errors.New("Could not replace database file " + db.path + ": " + err.Error())
+	}
+
+	// Make sure the rename itself survives a power loss
+	dir, err := os.Open(filepath.Dir(db.path))
+	if err != nil {
+		return errors.New("Could not open database directory: " + err.Error())
+	}
+	defer dir.Close()
+	err = dir.Sync()
+	if err != nil {
+		return errors.New("Could not sync database directory: " + err.Error())
+	}
+	return nil
+}
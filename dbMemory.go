@@ -0,0 +1,562 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type memoryKind int
+
+const (
+	memoryString memoryKind = iota
+	memoryHash
+	memoryList
+	memorySet
+)
+
+type memoryEntry struct {
+	kind    memoryKind
+	str     string
+	hash    map[string]string
+	list    []string  // Kept sorted and unique for sets
+	expires time.Time // Zero means the entry never expires
+}
+
+// MemoryDB is a BasicDB kept entirely in process memory.
+// It mirrors the Valkey semantics Nyooom relies on, so it can stand in for ValkeyDB on small hosts and in tests.
+type MemoryDB struct {
+	mu       sync.Mutex
+	entries  map[string]*memoryEntry
+	timeFunc TimeFunc
+	path     string // Where the data is persisted, empty for memory only
+}
+
+func NewMemoryDB(timeGenerator TimeFunc) *MemoryDB {
+	return &MemoryDB{
+		entries:  map[string]*memoryEntry{},
+		timeFunc: timeGenerator,
+	}
+}
+
+var errWrongType = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+
+// entry returns the live entry for a key, dropping it first if it has expired.
+// Callers must hold db.mu.
+func (db *MemoryDB) entry(key string) *memoryEntry {
+	entry, exists := db.entries[key]
+	if !exists {
+		return nil
+	}
+	if !entry.expires.IsZero() && !db.timeFunc().Before(entry.expires) {
+		delete(db.entries, key)
+		return nil
+	}
+	return entry
+}
+
+// entryOfKind returns the live entry for a key if it holds the expected kind of value.
+// A missing key returns nil without an error. Callers must hold db.mu.
+func (db *MemoryDB) entryOfKind(key string, kind memoryKind) (*memoryEntry, error) {
+	entry := db.entry(key)
+	if entry != nil && entry.kind != kind {
+		return nil, errWrongType
+	}
+	return entry, nil
+}
+
+func (db *MemoryDB) Exists(ctx context.Context, key string) (bool, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.exists(key), nil
+}
+
+func (db *MemoryDB) Get(ctx context.Context, key string) (string, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.get(key)
+}
+
+// Sets a key in the database with some duration.
+// If duration is 0, the key will be set with no expiration.
+func (db *MemoryDB) Set(ctx context.Context, key string, value string, duration time.Duration) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.write(key, func() error {
+		db.set(key, value, duration)
+		return nil
+	})
+}
+
+// Sets a key in the database with some duration only if the key is not already set.
+// Returns whether the key was set. If duration is 0, the key will be set with no expiration.
+func (db *MemoryDB) SetIfNotExists(ctx context.Context, key string, value string, duration time.Duration) (bool, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.exists(key) {
+		return false, nil
+	}
+	err := db.write(key, func() error {
+		db.set(key, value, duration)
+		return nil
+	})
+	return err == nil, err
+}
+
+func (db *MemoryDB) Delete(ctx context.Context, key string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.write(key, func() error {
+		delete(db.entries, key)
+		return nil
+	})
+}
+
+func (db *MemoryDB) GetHash(ctx context.Context, key string) (map[string]string, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.getHash(key)
+}
+
+// GetHashes fetches many hashes at once.
+// Missing keys give empty hashes, in the same position as their key.
+func (db *MemoryDB) GetHashes(ctx context.Context, keys []string) ([]map[string]string, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	hashes := make([]map[string]string, len(keys))
+	for i, key := range keys {
+		hash, err := db.getHash(key)
+		if err != nil {
+			return nil, err
+		}
+		hashes[i] = hash
+	}
+	return hashes, nil
+}
+
+func (db *MemoryDB) SetHash(ctx context.Context, key string, values map[string]string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.write(key, func() error {
+		return db.setHash(key, values)
+	})
+}
+
+func (db *MemoryDB) DeleteHash(ctx context.Context, key string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.write(key, func() error {
+		delete(db.entries, key)
+		return nil
+	})
+}
+
+func (db *MemoryDB) AddToList(ctx context.Context, key string, value string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.write(key, func() error {
+		return db.addToList(key, value)
+	})
+}
+
+func (db *MemoryDB) RemoveFromList(ctx context.Context, key string, value string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.write(key, func() error {
+		return db.removeFromList(key, value)
+	})
+}
+
+func (db *MemoryDB) GetList(ctx context.Context, key string) ([]string, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.getList(key)
+}
+
+func (db *MemoryDB) AddToSet(ctx context.Context, key string, value string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.write(key, func() error {
+		return db.addToSet(key, value)
+	})
+}
+
+func (db *MemoryDB) RemoveFromSet(ctx context.Context, key string, value string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.write(key, func() error {
+		return db.removeFromSet(key, value)
+	})
+}
+
+func (db *MemoryDB) GetSet(ctx context.Context, key string) ([]string, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.getSet(key)
+}
+
+// GetSets fetches many sets at once.
+// Missing keys give empty sets, in the same position as their key.
+func (db *MemoryDB) GetSets(ctx context.Context, keys []string) ([][]string, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	sets := make([][]string, len(keys))
+	for i, key := range keys {
+		set, err := db.getSet(key)
+		if err != nil {
+			return nil, err
+		}
+		sets[i] = set
+	}
+	return sets, nil
+}
+
+func (db *MemoryDB) IncrementHashField(ctx context.Context, key string, field string, amount int) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.write(key, func() error {
+		return db.incrementHashField(key, field, amount)
+	})
+}
+
+// Transaction holds the lock for the whole of fn, so nothing can change underneath it.
+// Writes are queued until fn succeeds. If one of them fails, or the result can't be saved,
+// the keys they touched are put back the way they were, so a transaction is applied all or nothing.
+func (db *MemoryDB) Transaction(ctx context.Context, keys []string, fn func(tx Tx) error) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	tx := &memoryTx{db: db}
+	err := fn(tx)
+	if err != nil {
+		return err
+	}
+	if len(tx.queued) == 0 {
+		return nil
+	}
+	before := map[string]*memoryEntry{} // Nil for keys that didn't exist
+	persisted := false
+	for _, write := range tx.queued {
+		persisted = persisted || slices.ContainsFunc(write.keys, db.persisted)
+		for _, key := range write.keys {
+			if _, saved := before[key]; !saved {
+				before[key] = db.entry(key).clone()
+			}
+		}
+		err = write.apply()
+		if err != nil {
+			db.restore(before)
+			return errors.New("Could not commit transaction: " + err.Error())
+		}
+	}
+	if !persisted {
+		return nil
+	}
+	err = db.save()
+	if err != nil { // Don't let memory get ahead of the file
+		db.restore(before)
+		return errors.New("Could not commit transaction: " + err.Error())
+	}
+	return nil
+}
+
+// write applies a change to a single key and saves it.
+// If either fails, the key is put back the way it was, like a transaction. Callers must hold db.mu.
+func (db *MemoryDB) write(key string, apply func() error) error {
+	before := map[string]*memoryEntry{key: db.entry(key).clone()}
+	err := apply()
+	if err == nil && db.persisted(key) {
+		err = db.save()
+	}
+	if err != nil {
+		db.restore(before)
+		return err
+	}
+	return nil
+}
+
+// restore puts keys back to entries saved before a transaction. Callers must hold db.mu.
+func (db *MemoryDB) restore(entries map[string]*memoryEntry) {
+	for key, entry := range entries {
+		if entry == nil {
+			delete(db.entries, key)
+		} else {
+			db.entries[key] = entry
+		}
+	}
+}
+
+// clone copies an entry, so writes to the original don't reach the copy
+func (entry *memoryEntry) clone() *memoryEntry {
+	if entry == nil {
+		return nil
+	}
+	copied := *entry
+	copied.hash = maps.Clone(entry.hash)
+	copied.list = slices.Clone(entry.list)
+	return &copied
+}
+
+// Operations shared by the public methods and transactions. Callers must hold db.mu.
+
+func (db *MemoryDB) exists(key string) bool {
+	return db.entry(key) != nil
+}
+
+func (db *MemoryDB) get(key string) (string, error) {
+	entry, err := db.entryOfKind(key, memoryString)
+	if err != nil {
+		return "", errors.New("Could not get key " + key + ": " + err.Error())
+	} else if entry == nil {
+		return "", fmt.Errorf("Could not get key %s: %w", key, ErrKeyNotFound)
+	}
+	return entry.str, nil
+}
+
+func (db *MemoryDB) set(key string, value string, duration time.Duration) {
+	entry := &memoryEntry{kind: memoryString, str: value}
+	if duration != 0 {
+		entry.expires = db.timeFunc().Add(duration)
+	}
+	db.entries[key] = entry
+}
+
+func (db *MemoryDB) getHash(key string) (map[string]string, error) {
+	entry, err := db.entryOfKind(key, memoryHash)
+	if err != nil {
+		return nil, errors.New("Could not get hash for key " + key + ": " + err.Error())
+	} else if entry == nil {
+		return map[string]string{}, nil
+	}
+	return maps.Clone(entry.hash), nil
+}
+
+func (db *MemoryDB) setHash(key string, values map[string]string) error {
+	if len(values) == 0 {
+		return errors.New("Could not set hash for key " + key + ": no fields provided")
+	}
+	entry, err := db.entryOfKind(key, memoryHash)
+	if err != nil {
+		return errors.New("Could not set hash for key " + key + ": " + err.Error())
+	} else if entry == nil {
+		entry = &memoryEntry{kind: memoryHash, hash: map[string]string{}}
+		db.entries[key] = entry
+	}
+	maps.Copy(entry.hash, values)
+	return nil
+}
+
+func (db *MemoryDB) addToList(key string, value string) error {
+	entry, err := db.entryOfKind(key, memoryList)
+	if err != nil {
+		return errors.New("Could not add value " + value + " to list " + key + ": " + err.Error())
+	} else if entry == nil {
+		entry = &memoryEntry{kind: memoryList}
+		db.entries[key] = entry
+	}
+	entry.list = slices.Insert(entry.list, 0, value) // Matches LPUSH, newest first
+	return nil
+}
+
+func (db *MemoryDB) removeFromList(key string, value string) error {
+	entry, err := db.entryOfKind(key, memoryList)
+	if err != nil {
+		return errors.New("Could not remove value " + value + " from list " + key + ": " + err.Error())
+	} else if entry == nil {
+		return nil
+	}
+	if index := slices.Index(entry.list, value); index != -1 {
+		entry.list = slices.Delete(entry.list, index, index+1)
+	}
+	if len(entry.list) == 0 { // Valkey drops empty lists
+		delete(db.entries, key)
+	}
+	return nil
+}
+
+func (db *MemoryDB) getList(key string) ([]string, error) {
+	entry, err := db.entryOfKind(key, memoryList)
+	if err != nil {
+		return nil, errors.New("Could not get list " + key + ": " + err.Error())
+	} else if entry == nil {
+		return []string{}, nil
+	}
+	return slices.Clone(entry.list), nil
+}
+
+func (db *MemoryDB) addToSet(key string, value string) error {
+	entry, err := db.entryOfKind(key, memorySet)
+	if err != nil {
+		return errors.New("Could not add value " + value + " to set " + key + ": " + err.Error())
+	} else if entry == nil {
+		entry = &memoryEntry{kind: memorySet}
+		db.entries[key] = entry
+	}
+	if index, found := slices.BinarySearch(entry.list, value); !found {
+		entry.list = slices.Insert(entry.list, index, value)
+	}
+	return nil
+}
+
+func (db *MemoryDB) removeFromSet(key string, value string) error {
+	entry, err := db.entryOfKind(key, memorySet)
+	if err != nil {
+		return errors.New("Could not remove value " + value + " from set " + key + ": " + err.Error())
+	} else if entry == nil {
+		return nil
+	}
+	if index, found := slices.BinarySearch(entry.list, value); found {
+		entry.list = slices.Delete(entry.list, index, index+1)
+	}
+	if len(entry.list) == 0 { // Valkey drops empty sets
+		delete(db.entries, key)
+	}
+	return nil
+}
+
+func (db *MemoryDB) getSet(key string) ([]string, error) {
+	entry, err := db.entryOfKind(key, memorySet)
+	if err != nil {
+		return nil, errors.New("Could not get set " + key + ": " + err.Error())
+	} else if entry == nil {
+		return []string{}, nil
+	}
+	return slices.Clone(entry.list), nil
+}
+
+func (db *MemoryDB) incrementHashField(key string, field string, amount int) error {
+	entry, err := db.entryOfKind(key, memoryHash)
+	if err != nil {
+		return errors.New("Could not increment hash field " + field + " for key " + key + " by " + strconv.Itoa(amount) + ": " + err.Error())
+	}
+	current := 0
+	if entry != nil {
+		if raw, exists := entry.hash[field]; exists {
+			current, err = strconv.Atoi(raw)
+			if err != nil {
+				return errors.New("Could not increment hash field " + field + " for key " + key + " by " + strconv.Itoa(amount) + ": hash value is not an integer")
+			}
+		}
+	} else {
+		entry = &memoryEntry{kind: memoryHash, hash: map[string]string{}}
+		db.entries[key] = entry
+	}
+	entry.hash[field] = strconv.Itoa(current + amount)
+	return nil
+}
+
+// memoryTx reads straight from the locked MemoryDB and queues its writes
+type memoryTx struct {
+	db     *MemoryDB
+	queued []memoryWrite
+}
+
+// memoryWrite is a queued write, along with the keys it changes
+type memoryWrite struct {
+	keys  []string
+	apply func() error
+}
+
+func (tx *memoryTx) queue(apply func() error, keys ...string) {
+	tx.queued = append(tx.queued, memoryWrite{keys: keys, apply: apply})
+}
+
+func (tx *memoryTx) Exists(ctx context.Context, key string) (bool, error) {
+	return tx.db.exists(key), nil
+}
+
+func (tx *memoryTx) Get(ctx context.Context, key string) (string, error) {
+	return tx.db.get(key)
+}
+
+func (tx *memoryTx) GetHash(ctx context.Context, key string) (map[string]string, error) {
+	return tx.db.getHash(key)
+}
+
+func (tx *memoryTx) GetList(ctx context.Context, key string) ([]string, error) {
+	return tx.db.getList(key)
+}
+
+func (tx *memoryTx) GetSet(ctx context.Context, key string) ([]string, error) {
+	return tx.db.getSet(key)
+}
+
+func (tx *memoryTx) Set(key string, value string, duration time.Duration) {
+	tx.queue(func() error {
+		tx.db.set(key, value, duration)
+		return nil
+	}, key)
+}
+
+func (tx *memoryTx) Delete(key string) {
+	tx.queue(func() error {
+		delete(tx.db.entries, key)
+		return nil
+	}, key)
+}
+
+func (tx *memoryTx) Rename(key string, newKey string) {
+	tx.queue(func() error {
+		entry := tx.db.entry(key)
+		if entry == nil {
+			return errors.New("Could not rename key " + key + " to " + newKey + ": no such key")
+		}
+		delete(tx.db.entries, key)
+		tx.db.entries[newKey] = entry
+		return nil
+	}, key, newKey)
+}
+
+func (tx *memoryTx) SetHash(key string, values map[string]string) {
+	values = maps.Clone(values)
+	tx.queue(func() error {
+		return tx.db.setHash(key, values)
+	}, key)
+}
+
+func (tx *memoryTx) AddToList(key string, value string) {
+	tx.queue(func() error {
+		return tx.db.addToList(key, value)
+	}, key)
+}
+
+func (tx *memoryTx) SetListItem(key string, index int, value string) {
+	tx.queue(func() error {
+		entry, err := tx.db.entryOfKind(key, memoryList)
+		if err != nil {
+			return errors.New("Could not set index " + strconv.Itoa(index) + " of list " + key + ": " + err.Error())
+		} else if entry == nil || index < 0 || index >= len(entry.list) {
+			return errors.New("Could not set index " + strconv.Itoa(index) + " of list " + key + ": index out of range")
+		}
+		entry.list[index] = value
+		return nil
+	}, key)
+}
+
+func (tx *memoryTx) RemoveFromList(key string, value string) {
+	tx.queue(func() error {
+		return tx.db.removeFromList(key, value)
+	}, key)
+}
+
+func (tx *memoryTx) AddToSet(key string, value string) {
+	tx.queue(func() error {
+		return tx.db.addToSet(key, value)
+	}, key)
+}
+
+func (tx *memoryTx) RemoveFromSet(key string, value string) {
+	tx.queue(func() error {
+		return tx.db.removeFromSet(key, value)
+	}, key)
+}
+
+func (tx *memoryTx) IncrementHashField(key string, field string, amount int) {
+	tx.queue(func() error {
+		return tx.db.incrementHashField(key, field, amount)
+	}, key)
+}
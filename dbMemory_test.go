@@ -0,0 +1,353 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMemoryDBExpiresKeys(t *testing.T) {
+	clock := newTestClock()
+	db := NewMemoryDB(clock.Now)
+	ctx := t.Context()
+
+	err := db.Set(ctx, "short", "lived", time.Minute)
+	if err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	err = db.Set(ctx, "forever", "here", 0)
+	if err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+
+	clock.Add(time.Minute - time.Second)
+	value, err := db.Get(ctx, "short")
+	if err != nil || value != "lived" {
+		t.Fatalf("Get before expiry = %q, %v, want lived", value, err)
+	}
+
+	clock.Add(time.Second)
+	_, err = db.Get(ctx, "short")
+	if !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Get after expiry error = %v, want ErrKeyNotFound", err)
+	}
+	exists, _ := db.Exists(ctx, "short")
+	if exists {
+		t.Error("Exists after expiry = true, want false")
+	}
+	value, err = db.Get(ctx, "forever")
+	if err != nil || value != "here" {
+		t.Errorf("Get without expiry = %q, %v, want here", value, err)
+	}
+}
+
+func TestMemoryDBSetIfNotExists(t *testing.T) {
+	clock := newTestClock()
+	db := NewMemoryDB(clock.Now)
+	ctx := t.Context()
+
+	set, err := db.SetIfNotExists(ctx, "lock", "first", time.Minute)
+	if err != nil || !set {
+		t.Fatalf("first SetIfNotExists = %v, %v, want true", set, err)
+	}
+	set, err = db.SetIfNotExists(ctx, "lock", "second", time.Minute)
+	if err != nil || set {
+		t.Fatalf("second SetIfNotExists = %v, %v, want false", set, err)
+	}
+	value, _ := db.Get(ctx, "lock")
+	if value != "first" {
+		t.Errorf("Get = %q, want the first value", value)
+	}
+
+	// Like SET NX, an expired key is free again
+	clock.Add(time.Minute)
+	set, err = db.SetIfNotExists(ctx, "lock", "third", 0)
+	if err != nil || !set {
+		t.Errorf("SetIfNotExists after expiry = %v, %v, want true", set, err)
+	}
+}
+
+func TestMemoryDBIncrementHashField(t *testing.T) {
+	db := NewMemoryDB(newTestClock().Now)
+	ctx := t.Context()
+
+	// Like HINCRBY, missing hashes and fields start at zero
+	err := db.IncrementHashField(ctx, "counter", "clicks", 2)
+	if err != nil {
+		t.Fatalf("IncrementHashField on a missing key: %v", err)
+	}
+	err = db.IncrementHashField(ctx, "counter", "clicks", 3)
+	if err != nil {
+		t.Fatalf("IncrementHashField: %v", err)
+	}
+	hash, _ := db.GetHash(ctx, "counter")
+	if hash["clicks"] != "5" {
+		t.Errorf("clicks = %q, want 5", hash["clicks"])
+	}
+
+	err = db.SetHash(ctx, "counter", map[string]string{"name": "not a number"})
+	if err != nil {
+		t.Fatalf("SetHash: %v", err)
+	}
+	err = db.IncrementHashField(ctx, "counter", "name", 1)
+	if err == nil {
+		t.Error("IncrementHashField on a non-integer field succeeded, want an error")
+	}
+}
+
+func TestMemoryDBListOrder(t *testing.T) {
+	db := NewMemoryDB(newTestClock().Now)
+	ctx := t.Context()
+
+	for _, value := range []string{"a", "b", "c"} {
+		err := db.AddToList(ctx, "list", value)
+		if err != nil {
+			t.Fatalf("AddToList(%s): %v", value, err)
+		}
+	}
+	// Like LPUSH then LRANGE 0 -1, newest first
+	list, err := db.GetList(ctx, "list")
+	if err != nil {
+		t.Fatalf("GetList: %v", err)
+	}
+	if !slices.Equal(list, []string{"c", "b", "a"}) {
+		t.Errorf("GetList = %v, want [c b a]", list)
+	}
+
+	// Valkey drops lists once they're empty
+	for _, value := range list {
+		db.RemoveFromList(ctx, "list", value)
+	}
+	exists, _ := db.Exists(ctx, "list")
+	if exists {
+		t.Error("empty list still exists")
+	}
+}
+
+func TestMemoryDBWrongType(t *testing.T) {
+	db := NewMemoryDB(newTestClock().Now)
+	ctx := t.Context()
+
+	db.Set(ctx, "string", "value", 0)
+	db.SetHash(ctx, "hash", map[string]string{"field": "value"})
+
+	checks := map[string]error{}
+	_, checks["GetHash on a string"] = db.GetHash(ctx, "string")
+	_, checks["GetList on a string"] = db.GetList(ctx, "string")
+	_, checks["GetSet on a hash"] = db.GetSet(ctx, "hash")
+	_, checks["Get on a hash"] = db.Get(ctx, "hash")
+	checks["AddToList on a hash"] = db.AddToList(ctx, "hash", "value")
+	checks["AddToSet on a string"] = db.AddToSet(ctx, "string", "value")
+	checks["IncrementHashField on a string"] = db.IncrementHashField(ctx, "string", "field", 1)
+	for name, err := range checks {
+		if err == nil || !strings.Contains(err.Error(), "WRONGTYPE") {
+			t.Errorf("%s error = %v, want WRONGTYPE", name, err)
+		}
+	}
+
+	// The failed writes didn't change the keys
+	value, _ := db.Get(ctx, "string")
+	if value != "value" {
+		t.Errorf("string = %q after failed writes, want value", value)
+	}
+}
+
+func TestFileDBSurvivesReopening(t *testing.T) {
+	clock := newTestClock()
+	path := t.TempDir() + "/nyooom-db.json"
+	db, err := NewFileDB(path, clock.Now)
+	if err != nil {
+		t.Fatalf("NewFileDB: %v", err)
+	}
+	ctx := t.Context()
+	db.SetHash(ctx, "hash", map[string]string{"field": "value"})
+	db.AddToSet(ctx, "set", "b")
+	db.AddToSet(ctx, "set", "a")
+	db.Set(ctx, "gone", "soon", time.Minute)
+
+	clock.Add(time.Hour)
+	reopened, err := NewFileDB(path, clock.Now)
+	if err != nil {
+		t.Fatalf("reopening NewFileDB: %v", err)
+	}
+	hash, _ := reopened.GetHash(ctx, "hash")
+	if hash["field"] != "value" {
+		t.Errorf("hash field = %q, want value", hash["field"])
+	}
+	set, _ := reopened.GetSet(ctx, "set")
+	if !slices.Equal(set, []string{"a", "b"}) {
+		t.Errorf("set = %v, want [a b]", set)
+	}
+	exists, _ := reopened.Exists(ctx, "gone")
+	if exists {
+		t.Error("expired key came back after reopening")
+	}
+}
+
+func TestMemoryDBTransactionIsAllOrNothing(t *testing.T) {
+	db := NewMemoryDB(newTestClock().Now)
+	ctx := t.Context()
+	db.SetHash(ctx, "link", map[string]string{"url": "https://example.com"})
+	db.AddToList(ctx, "links", "link")
+
+	err := db.Transaction(ctx, []string{"link"}, func(tx Tx) error {
+		tx.SetHash("link", map[string]string{"url": "https://example.org"})
+		tx.AddToList("links", "other")
+		tx.Set("new", "value", 0)
+		tx.Rename("missing", "renamed") // Fails after the writes above were applied
+		return nil
+	})
+	if err == nil {
+		t.Fatal("Transaction succeeded, want the failed rename's error")
+	}
+
+	hash, _ := db.GetHash(ctx, "link")
+	if hash["url"] != "https://example.com" {
+		t.Errorf("url = %q, want the write before the failure undone", hash["url"])
+	}
+	list, _ := db.GetList(ctx, "links")
+	if !slices.Equal(list, []string{"link"}) {
+		t.Errorf("links = %v, want [link]", list)
+	}
+	exists, _ := db.Exists(ctx, "new")
+	if exists {
+		t.Error("key created by the failed transaction still exists")
+	}
+}
+
+func TestMemoryDBTransactionSkipsWritesWhenFnFails(t *testing.T) {
+	db := NewMemoryDB(newTestClock().Now)
+	ctx := t.Context()
+
+	failure := errors.New("changed my mind")
+	err := db.Transaction(ctx, nil, func(tx Tx) error {
+		tx.Set("key", "value", 0)
+		return failure
+	})
+	if !errors.Is(err, failure) {
+		t.Errorf("Transaction error = %v, want fn's error", err)
+	}
+	exists, _ := db.Exists(ctx, "key")
+	if exists {
+		t.Error("write was applied even though fn failed")
+	}
+}
+
+// breakFileDB puts a directory in place of a database file. It can't be replaced, so saving fails.
+func breakFileDB(t *testing.T, path string) {
+	t.Helper()
+	err := os.Remove(path)
+	if err == nil {
+		err = os.Mkdir(path, 0o755)
+	}
+	if err != nil {
+		t.Fatalf("replacing database file with a directory: %v", err)
+	}
+}
+
+func TestFileDBTransactionReportsSaveErrors(t *testing.T) {
+	path := t.TempDir() + "/nyooom-db.json"
+	db, err := NewFileDB(path, newTestClock().Now)
+	if err != nil {
+		t.Fatalf("NewFileDB: %v", err)
+	}
+	ctx := t.Context()
+	db.Set(ctx, "kept", "value", 0)
+
+	breakFileDB(t, path)
+	err = db.Transaction(ctx, nil, func(tx Tx) error {
+		tx.Set("kept", "changed", 0)
+		tx.Set("added", "value", 0)
+		return nil
+	})
+	if err == nil {
+		t.Fatal("Transaction succeeded even though it couldn't be saved")
+	}
+	value, _ := db.Get(ctx, "kept")
+	if value != "value" {
+		t.Errorf("kept = %q, want memory to still match the file", value)
+	}
+	exists, _ := db.Exists(ctx, "added")
+	if exists {
+		t.Error("unsaved key is still in memory")
+	}
+}
+
+func TestFileDBWritesReportSaveErrors(t *testing.T) {
+	path := t.TempDir() + "/nyooom-db.json"
+	db, err := NewFileDB(path, newTestClock().Now)
+	if err != nil {
+		t.Fatalf("NewFileDB: %v", err)
+	}
+	ctx := t.Context()
+	db.Set(ctx, "string", "value", 0)
+	db.SetHash(ctx, "hash", map[string]string{"clicks": "1"})
+	db.AddToList(ctx, "list", "a")
+	db.AddToSet(ctx, "set", "a")
+
+	breakFileDB(t, path)
+	writes := map[string]error{
+		"Set":                db.Set(ctx, "string", "changed", 0),
+		"Delete":             db.Delete(ctx, "string"),
+		"SetHash":            db.SetHash(ctx, "hash", map[string]string{"clicks": "5"}),
+		"IncrementHashField": db.IncrementHashField(ctx, "hash", "clicks", 1),
+		"AddToList":          db.AddToList(ctx, "list", "b"),
+		"RemoveFromList":     db.RemoveFromList(ctx, "list", "a"),
+		"AddToSet":           db.AddToSet(ctx, "set", "b"),
+		"RemoveFromSet":      db.RemoveFromSet(ctx, "set", "a"),
+	}
+	set, err := db.SetIfNotExists(ctx, "new", "value", 0)
+	writes["SetIfNotExists"] = err
+	if set {
+		t.Error("SetIfNotExists reported setting a key it couldn't save")
+	}
+	for name, err := range writes {
+		if err == nil {
+			t.Errorf("%s succeeded even though it couldn't be saved", name)
+		}
+	}
+
+	// Memory still matches the file
+	value, _ := db.Get(ctx, "string")
+	hash, _ := db.GetHash(ctx, "hash")
+	list, _ := db.GetList(ctx, "list")
+	members, _ := db.GetSet(ctx, "set")
+	exists, _ := db.Exists(ctx, "new")
+	if value != "value" || hash["clicks"] != "1" || !slices.Equal(list, []string{"a"}) || !slices.Equal(members, []string{"a"}) || exists {
+		t.Errorf("string %q, hash %v, list %v, set %v, new key %v, want the unsaved writes undone", value, hash, list, members, exists)
+	}
+}
+
+func TestFileDBRebuildsSearchIndex(t *testing.T) {
+	clock := newTestClock()
+	path := t.TempDir() + "/nyooom-db.json"
+	fileDB, err := NewFileDB(path, clock.Now)
+	if err != nil {
+		t.Fatalf("NewFileDB: %v", err)
+	}
+	mustSetLink(t, DB{basicDB: fileDB, timeFunc: clock.Now}, Link{Slug: "docs", URL: "https://example.com", Title: "Team handbook"})
+
+	raw, _ := os.ReadFile(path)
+	if strings.Contains(string(raw), "search:") || strings.Contains(string(raw), "search-prefix:") {
+		t.Error("search index was written to the database file")
+	}
+
+	reopened, err := NewFileDB(path, clock.Now)
+	if err != nil {
+		t.Fatalf("reopening NewFileDB: %v", err)
+	}
+	db := DB{basicDB: reopened, timeFunc: clock.Now}
+	err = indexAllLinks(t.Context(), db)
+	if err != nil {
+		t.Fatalf("indexAllLinks: %v", err)
+	}
+	query := defaultLinkQuery()
+	query.Search = "handbook"
+	page, err := db.GetLinks(t.Context(), query)
+	if err != nil || len(page.Links) != 1 {
+		t.Fatalf("search after reopening = %v, %v, want the link", page.Links, err)
+	}
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLinkAnalyticsNeverOvershootsClickLimit(t *testing.T) {
+	clock := newTestClock()
+	db := newTestDB(clock)
+	mustSetLink(t, db, Link{Slug: "once", URL: "https://example.com", MaxClicks: 5})
+
+	const visitors = 50
+	var wg sync.WaitGroup
+	results := make(chan error, visitors)
+	for range visitors {
+		wg.Go(func() {
+			results <- db.LinkAnalytics(t.Context(), "once", 1, clock.Now())
+		})
+	}
+	wg.Wait()
+	close(results)
+
+	counted, usedUp := 0, 0
+	for err := range results {
+		switch {
+		case err == nil:
+			counted++
+		case errors.Is(err, ErrLinkUsedUp):
+			usedUp++
+		default:
+			t.Errorf("LinkAnalytics: %v", err)
+		}
+	}
+	if counted != 5 || usedUp != visitors-5 {
+		t.Errorf("%d clicks counted and %d turned away, want 5 and %d", counted, usedUp, visitors-5)
+	}
+	if link := mustGetLink(t, db, "once"); link.Clicks != 5 {
+		t.Errorf("clicks = %d, want 5", link.Clicks)
+	}
+}
+
+func TestLinkAnalyticsDoesNotRecreateDeletedLinks(t *testing.T) {
+	db := newTestDB(newTestClock())
+
+	err := db.LinkAnalytics(t.Context(), "gone", 1, db.timeFunc())
+	if !errors.Is(err, ErrLinkNotFound) {
+		t.Errorf("LinkAnalytics error = %v, want ErrLinkNotFound", err)
+	}
+	exists, _ := db.basicDB.Exists(t.Context(), "gone")
+	if exists {
+		t.Error("click brought back a link hash with no URL")
+	}
+}
+
+// rename moves a link to a new slug without changing anything else about it
+func rename(t *testing.T, db DB, slug string, newSlug string) {
+	t.Helper()
+	err := db.UpdateLink(t.Context(), slug, newSlug, "api", func(link *Link) error { return nil })
+	if err != nil {
+		t.Fatalf("renaming %s to %s: %v", slug, newSlug, err)
+	}
+}
+
+func TestAliasesSurviveRenames(t *testing.T) {
+	for _, caseInsensitive := range []bool{false, true} {
+		name := "case-sensitive"
+		if caseInsensitive {
+			name = "case-insensitive"
+		}
+		t.Run(name, func(t *testing.T) {
+			original := caseInsensitiveSlugs
+			caseInsensitiveSlugs = caseInsensitive
+			t.Cleanup(func() { caseInsensitiveSlugs = original })
+
+			db := newTestDB(newTestClock())
+			ctx := t.Context()
+			mustSetLink(t, db, Link{Slug: "docs", URL: "https://example.com/docs"})
+			err := db.AddAlias(ctx, "docs", "guide")
+			if err != nil {
+				t.Fatalf("AddAlias: %v", err)
+			}
+			rename(t, db, "docs", "manual")
+
+			link, err := db.ResolveLink(ctx, "guide")
+			if err != nil || link.Slug != "manual" {
+				t.Fatalf("ResolveLink(guide) = %s, %v, want the renamed link", link.Slug, err)
+			}
+			if !slices.Equal(link.Aliases, []string{"guide"}) {
+				t.Errorf("aliases = %v, want [guide]", link.Aliases)
+			}
+			if caseInsensitive {
+				link, err = db.ResolveLink(ctx, "GUIDE")
+				if err != nil || link.Slug != "manual" {
+					t.Errorf("ResolveLink(GUIDE) = %s, %v, want the renamed link", link.Slug, err)
+				}
+				link, err = db.ResolveLink(ctx, "Manual")
+				if err != nil || link.Slug != "manual" {
+					t.Errorf("ResolveLink(Manual) = %s, %v, want the renamed link", link.Slug, err)
+				}
+			}
+
+			// The alias is still taken, and the old slug is free again
+			err = db.SetLink(ctx, Link{Slug: "guide", URL: "https://example.org", Kind: LinkKindStandard})
+			if !errors.Is(err, ErrLinkExists) {
+				t.Errorf("creating a link at the alias error = %v, want ErrLinkExists", err)
+			}
+			free, err := db.IsSlugFree(ctx, "docs")
+			if err != nil || !free {
+				t.Errorf("IsSlugFree(docs) = %v, %v, want the old slug freed", free, err)
+			}
+			if _, err := db.ResolveLink(ctx, "docs"); !errors.Is(err, ErrLinkNotFound) {
+				t.Errorf("ResolveLink(docs) error = %v, want ErrLinkNotFound", err)
+			}
+
+			// Renaming to the link's own alias would leave two ways to claim it
+			err = db.UpdateLink(ctx, "manual", "guide", "api", func(link *Link) error { return nil })
+			if !errors.Is(err, ErrLinkExists) {
+				t.Errorf("renaming onto an alias error = %v, want ErrLinkExists", err)
+			}
+		})
+	}
+}
+
+func TestAliasesSharingCaseVariants(t *testing.T) {
+	original := caseInsensitiveSlugs
+	caseInsensitiveSlugs = true
+	t.Cleanup(func() { caseInsensitiveSlugs = original })
+
+	db := newTestDB(newTestClock())
+	ctx := t.Context()
+	mustSetLink(t, db, Link{Slug: "fresh", URL: "https://example.com"})
+	for _, alias := range []string{"FRESH", "Go", "GO"} {
+		err := db.AddAlias(ctx, "fresh", alias)
+		if err != nil {
+			t.Fatalf("AddAlias(%s): %v", alias, err)
+		}
+	}
+	resolves := func(slug string) {
+		t.Helper()
+		link, err := db.ResolveLink(ctx, slug)
+		if err != nil || link.Slug != "fresh" {
+			t.Errorf("ResolveLink(%s) = %s, %v, want the link", slug, link.Slug, err)
+		}
+	}
+
+	// The link's own slug still folds to it
+	err := db.RemoveAlias(ctx, "fresh", "FRESH")
+	if err != nil {
+		t.Fatalf("RemoveAlias(FRESH): %v", err)
+	}
+	resolves("Fresh")
+
+	// As does the other alias
+	err = db.RemoveAlias(ctx, "fresh", "GO")
+	if err != nil {
+		t.Fatalf("RemoveAlias(GO): %v", err)
+	}
+	resolves("go")
+	if err := db.SetLink(ctx, Link{Slug: "gO", URL: "https://example.org", Kind: LinkKindStandard}); !errors.Is(err, ErrLinkExists) {
+		t.Errorf("creating a case variant of a kept alias error = %v, want ErrLinkExists", err)
+	}
+
+	// Renaming keeps the variants an alias shares with the old slug
+	err = db.AddAlias(ctx, "fresh", "FRESH")
+	if err != nil {
+		t.Fatalf("AddAlias(FRESH): %v", err)
+	}
+	rename(t, db, "fresh", "new")
+	link, err := db.ResolveLink(ctx, "Fresh")
+	if err != nil || link.Slug != "new" {
+		t.Errorf("ResolveLink(Fresh) = %s, %v, want the renamed link", link.Slug, err)
+	}
+
+	// Once nothing is left folding to them, the variants are free
+	err = db.RemoveAlias(ctx, "new", "Go")
+	if err != nil {
+		t.Fatalf("RemoveAlias(Go): %v", err)
+	}
+	free, err := db.IsSlugFree(ctx, "go")
+	if err != nil || !free {
+		t.Errorf("IsSlugFree(go) = %v, %v, want it freed", free, err)
+	}
+}
+
+func TestTrashKeepsSlugsReserved(t *testing.T) {
+	clock := newTestClock()
+	db := newTestDB(clock)
+	ctx := t.Context()
+	mustSetLink(t, db, Link{Slug: "docs", URL: "https://example.com/docs"})
+	mustSetLink(t, db, Link{Slug: "other", URL: "https://example.org"})
+	err := db.AddAlias(ctx, "docs", "guide")
+	if err != nil {
+		t.Fatalf("AddAlias: %v", err)
+	}
+	err = db.TrashLink(ctx, "docs", "api")
+	if err != nil {
+		t.Fatalf("TrashLink: %v", err)
+	}
+
+	for _, slug := range []string{"docs", "guide"} {
+		if _, err := db.ResolveLink(ctx, slug); !errors.Is(err, ErrLinkNotFound) {
+			t.Errorf("ResolveLink(%s) error = %v, want trashed links to stop redirecting", slug, err)
+		}
+		free, err := db.IsSlugFree(ctx, slug)
+		if err != nil || free {
+			t.Errorf("IsSlugFree(%s) = %v, %v, want it reserved", slug, free, err)
+		}
+		err = db.SetLink(ctx, Link{Slug: slug, URL: "https://example.net", Kind: LinkKindStandard})
+		if !errors.Is(err, ErrLinkExists) {
+			t.Errorf("creating %s error = %v, want ErrLinkExists", slug, err)
+		}
+		err = db.AddAlias(ctx, "other", slug)
+		if !errors.Is(err, ErrLinkExists) {
+			t.Errorf("aliasing %s error = %v, want ErrLinkExists", slug, err)
+		}
+		err = db.UpdateLink(ctx, "other", slug, "api", func(link *Link) error { return nil })
+		if !errors.Is(err, ErrLinkExists) {
+			t.Errorf("renaming onto %s error = %v, want ErrLinkExists", slug, err)
+		}
+	}
+
+	restored, err := db.RestoreLink(ctx, "docs", "api")
+	if err != nil {
+		t.Fatalf("RestoreLink: %v", err)
+	}
+	if restored.URL != "https://example.com/docs" {
+		t.Errorf("restored url = %q, want the link as it was", restored.URL)
+	}
+	link, err := db.ResolveLink(ctx, "guide")
+	if err != nil || link.Slug != "docs" {
+		t.Errorf("ResolveLink(guide) = %s, %v, want the restored link", link.Slug, err)
+	}
+
+	// Purging is what finally frees the slug and its aliases
+	err = db.TrashLink(ctx, "docs", "api")
+	if err != nil {
+		t.Fatalf("TrashLink: %v", err)
+	}
+	retention := 30 * 24 * time.Hour
+	purged, err := db.PurgeExpiredTrash(ctx, retention)
+	if err != nil || purged != 0 {
+		t.Fatalf("PurgeExpiredTrash before retention = %d, %v, want nothing purged", purged, err)
+	}
+	clock.Add(retention)
+	purged, err = db.PurgeExpiredTrash(ctx, retention)
+	if err != nil || purged != 1 {
+		t.Fatalf("PurgeExpiredTrash = %d, %v, want the link purged", purged, err)
+	}
+	for _, slug := range []string{"docs", "guide"} {
+		free, err := db.IsSlugFree(ctx, slug)
+		if err != nil || !free {
+			t.Errorf("IsSlugFree(%s) after purging = %v, %v, want it free", slug, free, err)
+		}
+	}
+	if _, err := db.RestoreLink(ctx, "docs", "api"); err == nil {
+		t.Error("restored a purged link")
+	}
+}
+
+// pageSlugs lists the slugs of a page of links, in order
+func pageSlugs(page LinkPage) []string {
+	slugs := make([]string, len(page.Links))
+	for i, link := range page.Links {
+		slugs[i] = link.Slug
+	}
+	return slugs
+}
+
+func TestGetLinksSortsAndPages(t *testing.T) {
+	clock := newTestClock()
+	db := newTestDB(clock)
+	ctx := t.Context()
+	for i, slug := range []string{"first", "second", "third", "fourth"} {
+		mustSetLink(t, db, Link{Slug: slug, URL: "https://example.com/" + slug, Clicks: 10 - i})
+		clock.Add(time.Minute)
+	}
+	mustSetLink(t, db, Link{Slug: "also-fourth", URL: "https://example.com", CreatedAt: mustGetLink(t, db, "fourth").CreatedAt})
+
+	// Restoring puts a link back at the top of the links list, but it was still created first
+	err := db.TrashLink(ctx, "first", "api")
+	if err == nil {
+		_, err = db.RestoreLink(ctx, "first", "api")
+	}
+	if err != nil {
+		t.Fatalf("trashing and restoring: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		query LinkQuery
+		want  []string
+	}{
+		{"newest first", LinkQuery{Sort: LinkSortCreated, Descending: true, Limit: 10}, []string{"fourth", "also-fourth", "third", "second", "first"}},
+		{"oldest first", LinkQuery{Sort: LinkSortCreated, Limit: 10}, []string{"first", "second", "third", "also-fourth", "fourth"}},
+		{"most clicked", LinkQuery{Sort: LinkSortClicks, Descending: true, Limit: 3}, []string{"first", "second", "third"}},
+		{"by slug", LinkQuery{Sort: LinkSortSlug, Limit: 2}, []string{"also-fourth", "first"}},
+		{"second page", LinkQuery{Sort: LinkSortCreated, Descending: true, Offset: 2, Limit: 2}, []string{"third", "second"}},
+		{"past the end", LinkQuery{Sort: LinkSortCreated, Offset: 10, Limit: 2}, []string{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			page, err := db.GetLinks(ctx, test.query)
+			if err != nil {
+				t.Fatalf("GetLinks: %v", err)
+			}
+			if !slices.Equal(pageSlugs(page), test.want) {
+				t.Errorf("links = %v, want %v", pageSlugs(page), test.want)
+			}
+			if page.Total != 5 {
+				t.Errorf("total = %d, want every link counted", page.Total)
+			}
+		})
+	}
+
+	page, _ := db.GetLinks(ctx, LinkQuery{Sort: LinkSortCreated, Offset: 2, Limit: 2})
+	if !page.HasPrevious() || !page.HasNext() || page.PreviousOffset() != 0 || page.NextOffset() != 4 {
+		t.Errorf("previous %v at %d, next %v at %d, want pages either side", page.HasPrevious(), page.PreviousOffset(), page.HasNext(), page.NextOffset())
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"errors"
+	"maps"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// Schemes links can point to when ALLOWED_URL_SCHEMES isn't set
+var defaultURLSchemes = []string{"http", "https", "ftp", "mailto"}
+
+// Schemes that run code or read local files in the visitor's browser, never allowed even if configured
+var blockedURLSchemes = []string{"javascript", "data", "vbscript", "file", "blob"}
+
+var allowedURLSchemes = loadAllowedURLSchemes()
+
+var urlSchemePattern = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9+.-]*$`)
+
+// loadAllowedURLSchemes reads the comma separated ALLOWED_URL_SCHEMES, so app deep links like "slack" can be added
+func loadAllowedURLSchemes() []string {
+	raw := os.Getenv("ALLOWED_URL_SCHEMES")
+	if raw == "" {
+		return defaultURLSchemes
+	}
+	schemes := []string{}
+	for scheme := range strings.SplitSeq(raw, ",") {
+		scheme = strings.ToLower(strings.TrimSpace(scheme))
+		scheme = strings.TrimSuffix(scheme, "://")
+		scheme = strings.TrimSuffix(scheme, ":")
+		if scheme != "" {
+			schemes = append(schemes, scheme)
+		}
+	}
+	return schemes
+}
+
+// cleanURL validates a destination URL and puts it in the form links store it.
+// Destinations without a scheme are assumed to be https, and internationalized hosts are stored as punycode.
+func cleanURL(rawURL string) (string, error) {
+	rawURL = strings.TrimSpace(rawURL)
+	if rawURL == "" {
+		return "", errors.New("Invalid URL: destination is empty")
+	}
+	// Browsers quietly drop tabs and newlines, which can hide a scheme like "java\tscript:"
+	if strings.ContainsFunc(rawURL, func(r rune) bool { return r <= ' ' || r == 0x7f }) {
+		return "", errors.New("Invalid URL: " + rawURL + " contains spaces or control characters")
+	}
+
+	inferredScheme := !hasURLScheme(rawURL)
+	if inferredScheme {
+		rawURL = "https://" + rawURL
+	}
+	destination, err := url.Parse(rawURL)
+	if err != nil {
+		return "", errors.New("Invalid URL: " + err.Error())
+	}
+
+	destination.Scheme = strings.ToLower(destination.Scheme)
+	if slices.Contains(blockedURLSchemes, destination.Scheme) {
+		return "", errors.New("Invalid URL: " + destination.Scheme + " links are not allowed")
+	}
+	if !slices.Contains(allowedURLSchemes, destination.Scheme) {
+		return "", errors.New("Invalid URL: " + destination.Scheme + " is not an allowed scheme, allowed schemes are " + strings.Join(allowedURLSchemes, ", "))
+	}
+
+	// "http:example.com" parses as opaque, and browsers treat it as a path on the current site
+	if destination.Host == "" && (destination.Scheme == "http" || destination.Scheme == "https" || destination.Scheme == "ftp") {
+		return "", errors.New("Invalid URL: " + rawURL + " is missing a host")
+	}
+	if destination.Opaque != "" || destination.Host == "" { // Like mailto:someone@example.com, or app deep links without hosts
+		return destination.String(), nil
+	}
+
+	hostname := destination.Hostname()
+	if net.ParseIP(hostname) != nil { // IP addresses don't need converting
+		return destination.String(), nil
+	}
+	hostname, err = idna.Lookup.ToASCII(hostname)
+	if err != nil {
+		return "", errors.New("Invalid URL: invalid host " + destination.Hostname() + ": " + err.Error())
+	}
+	// Without a scheme, a single word is more likely a typo than an intranet host
+	if inferredScheme && !strings.Contains(hostname, ".") && destination.Port() == "" {
+		return "", errors.New("Invalid URL: " + hostname + " doesn't look like a website, add a scheme like http:// to link to it anyway")
+	}
+	if port := destination.Port(); port != "" {
+		hostname += ":" + port
+	}
+	destination.Host = hostname
+	return destination.String(), nil
+}
+
+// hasURLScheme reports whether a destination starts with a scheme, as opposed to a bare host like "example.com:8080"
+func hasURLScheme(rawURL string) bool {
+	scheme, rest, found := strings.Cut(rawURL, ":")
+	if !found || !urlSchemePattern.MatchString(scheme) {
+		return false
+	}
+	port, _, _ := strings.Cut(rest, "/")
+	if port != "" && strings.Trim(port, "0123456789") == "" { // A host followed by a port
+		return false
+	}
+	return true
+}
+
+// Placeholders in template links, like {1} for the first path segment or {name} for the query parameter "name"
+var (
+	placeholderPattern     = regexp.MustCompile(`\{([^{}]*)\}`)
+	placeholderNamePattern = regexp.MustCompile(`^([1-9][0-9]*|[a-zA-Z_][a-zA-Z0-9_-]*)$`)
+)
+
+// cleanTemplateURL is cleanURL for template links, whose placeholders may only be in the path, query or fragment
+func cleanTemplateURL(rawURL string) (string, error) {
+	// Swap placeholders for plain words so the rest of the URL can be checked like any other
+	names := []string{}
+	standIn := func(i int) string { return "nyooomplaceholder" + strconv.Itoa(i) + "x" }
+	withStandIns := placeholderPattern.ReplaceAllStringFunc(rawURL, func(placeholder string) string {
+		names = append(names, placeholder[1:len(placeholder)-1])
+		return standIn(len(names) - 1)
+	})
+	if strings.ContainsAny(withStandIns, "{}") {
+		return "", errors.New("Invalid template URL: " + rawURL + " has unmatched braces")
+	}
+	for _, name := range names {
+		if !placeholderNamePattern.MatchString(name) {
+			return "", errors.New("Invalid template URL: {" + name + "} should be a path segment number like {1} or a query parameter name like {q}")
+		}
+	}
+
+	cleaned, err := cleanURL(withStandIns)
+	if err != nil {
+		return "", err
+	}
+	destination, err := url.Parse(cleaned)
+	if err != nil {
+		return "", errors.New("Invalid template URL: " + err.Error())
+	}
+	if strings.Contains(destination.Scheme+destination.Host, "nyooomplaceholder") { // Visitors could send people anywhere
+		return "", errors.New("Invalid template URL: placeholders can't be used in the scheme or host")
+	}
+	for i, name := range names {
+		cleaned = strings.Replace(cleaned, standIn(i), "{"+name+"}", 1)
+	}
+	return cleaned, nil
+}
+
+// expandTemplate fills in the placeholders of a template URL from a visitor's path segments and query.
+// The path segments and query parameters that weren't used are returned, so they can be passed through.
+func expandTemplate(template string, extraPath string, query url.Values) (string, string, url.Values, error) {
+	segments := []string{}
+	if extraPath != "" {
+		segments = strings.Split(extraPath, "/")
+	}
+	query = maps.Clone(query)
+	queryStart := strings.IndexAny(template, "?#")
+	usedSegments := 0
+	usedParameters := []string{}
+
+	var expanded strings.Builder
+	last := 0
+	for _, match := range placeholderPattern.FindAllStringSubmatchIndex(template, -1) {
+		expanded.WriteString(template[last:match[0]])
+		last = match[1]
+
+		name := template[match[2]:match[3]]
+		value := ""
+		if position, err := strconv.Atoi(name); err == nil {
+			if position <= len(segments) {
+				value = segments[position-1]
+				usedSegments = max(usedSegments, position)
+			}
+		} else {
+			value = query.Get(name)
+			usedParameters = append(usedParameters, name)
+		}
+		if value == "" || value == "." || value == ".." {
+			return "", "", nil, errors.New("Could not expand template " + template + ": no value for {" + name + "}")
+		}
+
+		if queryStart != -1 && match[0] > queryStart {
+			expanded.WriteString(url.QueryEscape(value))
+		} else {
+			expanded.WriteString(url.PathEscape(value))
+		}
+	}
+	expanded.WriteString(template[last:])
+
+	for _, name := range usedParameters {
+		query.Del(name)
+	}
+	return expanded.String(), strings.Join(segments[usedSegments:], "/"), query, nil
+}
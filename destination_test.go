@@ -0,0 +1,137 @@
+package main
+
+import (
+	"maps"
+	"net/url"
+	"slices"
+	"testing"
+)
+
+func TestCleanURL(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want string // Empty when the URL should be rejected
+	}{
+		{"example.com", "https://example.com"},
+		{"  example.com/docs?q=1  ", "https://example.com/docs?q=1"},
+		{"example.com:8080/admin", "https://example.com:8080/admin"},
+		{"example.com/?r=https://example.org", "https://example.com/?r=https://example.org"},
+		{"HTTP://example.com", "http://example.com"},
+		{"http://intranet", "http://intranet"},
+		{"bücher.de/regal", "https://xn--bcher-kva.de/regal"},
+		{"192.168.1.1:3000", "https://192.168.1.1:3000"},
+		{"mailto:team@example.com", "mailto:team@example.com"},
+		{"ftp://files.example.com/a.zip", "ftp://files.example.com/a.zip"},
+		{"", ""},
+		{"intranet", ""},
+		{"javascript:alert(1)", ""},
+		{"JavaScript:alert(1)", ""},
+		{"java\tscript:alert(1)", ""},
+		{"data:text/html,hi", ""},
+		{"slack://open", ""},
+		{"//evil.com", ""},
+		{"http:evil.com", ""},
+		{"https:evil.com/path", ""},
+		{"http://", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.raw, func(t *testing.T) {
+			got, err := cleanURL(test.raw)
+			if test.want == "" {
+				if err == nil {
+					t.Errorf("cleanURL(%q) = %q, want an error", test.raw, got)
+				}
+				return
+			}
+			if err != nil || got != test.want {
+				t.Errorf("cleanURL(%q) = %q, %v, want %q", test.raw, got, err, test.want)
+			}
+		})
+	}
+}
+
+func TestAllowedURLSchemesCanBeExtended(t *testing.T) {
+	t.Setenv("ALLOWED_URL_SCHEMES", "https, slack://, javascript")
+	original := allowedURLSchemes
+	allowedURLSchemes = loadAllowedURLSchemes()
+	t.Cleanup(func() { allowedURLSchemes = original })
+
+	if got, err := cleanURL("slack://open?team=T1"); err != nil || got != "slack://open?team=T1" {
+		t.Errorf("cleanURL(slack) = %q, %v, want the configured scheme allowed", got, err)
+	}
+	if _, err := cleanURL("http://example.com"); err == nil {
+		t.Error("cleanURL allowed http after it was left out")
+	}
+	if _, err := cleanURL("javascript:alert(1)"); err == nil {
+		t.Error("cleanURL allowed javascript because it was configured")
+	}
+}
+
+func TestCleanTemplateURL(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want string // Empty when the template should be rejected
+	}{
+		{"github.com/{1}/{2}", "https://github.com/{1}/{2}"},
+		{"https://www.google.com/search?q={q}", "https://www.google.com/search?q={q}"},
+		{"https://example.com/docs#{section}", "https://example.com/docs#{section}"},
+		{"https://{1}.example.com", ""},
+		{"https://example.{tld}/", ""},
+		{"{scheme}://example.com", ""},
+		{"https://example.com/{1", ""},
+		{"https://example.com/{}", ""},
+		{"https://example.com/{0}", ""},
+		{"https://example.com/{two words}", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.raw, func(t *testing.T) {
+			got, err := cleanTemplateURL(test.raw)
+			if test.want == "" {
+				if err == nil {
+					t.Errorf("cleanTemplateURL(%q) = %q, want an error", test.raw, got)
+				}
+				return
+			}
+			if err != nil || got != test.want {
+				t.Errorf("cleanTemplateURL(%q) = %q, %v, want %q", test.raw, got, err, test.want)
+			}
+		})
+	}
+}
+
+func TestExpandTemplate(t *testing.T) {
+	tests := []struct {
+		name      string
+		template  string
+		extraPath string
+		query     url.Values
+		want      string
+		wantPath  string
+		wantQuery url.Values
+	}{
+		{"path segments", "https://github.com/{1}/{2}", "golang/go", nil, "https://github.com/golang/go", "", url.Values{}},
+		{"unused segments are left over", "https://github.com/{1}", "golang/go/issues", nil, "https://github.com/golang", "go/issues", url.Values{}},
+		{"query parameter", "https://example.com/search?q={q}", "", url.Values{"q": {"a b&c"}, "page": {"2"}}, "https://example.com/search?q=a+b%26c", "", url.Values{"page": {"2"}}},
+		{"escaped path", "https://example.com/users/{1}", "a%3Fb", nil, "https://example.com/users/a%253Fb", "", url.Values{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, path, query, err := expandTemplate(test.template, test.extraPath, test.query)
+			if err != nil {
+				t.Fatalf("expandTemplate: %v", err)
+			}
+			if got != test.want || path != test.wantPath || !maps.EqualFunc(query, test.wantQuery, slices.Equal) {
+				t.Errorf("expandTemplate = %q, %q, %v, want %q, %q, %v", got, path, query, test.want, test.wantPath, test.wantQuery)
+			}
+		})
+	}
+
+	for _, extraPath := range []string{"", "..", "."} {
+		if _, _, _, err := expandTemplate("https://example.com/{1}", extraPath, nil); err == nil {
+			t.Errorf("expandTemplate with path %q succeeded, want an error for the missing value", extraPath)
+		}
+	}
+	if _, _, _, err := expandTemplate("https://example.com/?q={q}", "", url.Values{}); err == nil {
+		t.Error("expandTemplate without the query parameter succeeded, want an error")
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"net/http"
+	"nyooom/logging"
+	"slices"
+	"time"
+)
+
+// Each link has a list of what's happened to it at historyKey(slug), newest first.
+// Entries are only ever added, moving along with the link when it's renamed, until the link is purged from the trash.
+func historyKey(slug string) string {
+	return "history:" + slug
+}
+
+// HistoryAction is what happened to a link
+type HistoryAction string
+
+const (
+	HistoryCreated    HistoryAction = "created"
+	HistoryURLChanged HistoryAction = "url_changed"
+	HistoryRolledBack HistoryAction = "rolled_back" // The destination was changed back to one it had before
+	HistoryRenamed    HistoryAction = "renamed"
+	HistoryDisabled   HistoryAction = "disabled"
+	HistoryEnabled    HistoryAction = "enabled"
+	HistoryDeleted    HistoryAction = "deleted" // Moved to the trash
+	HistoryRestored   HistoryAction = "restored"
+)
+
+// HistoryEntry is one change to a link
+type HistoryEntry struct {
+	At      time.Time     `json:"at"`
+	Actor   string        `json:"actor"` // From requestActor
+	Action  HistoryAction `json:"action"`
+	Slug    string        `json:"slug"` // What the link was called after the change
+	OldSlug string        `json:"old_slug,omitempty"`
+	URL     string        `json:"url,omitempty"` // Where the link pointed after the change
+	OldURL  string        `json:"old_url,omitempty"`
+}
+
+// recordHistory adds an entry to the history of the link at slug
+func recordHistory(tx Tx, slug string, entry HistoryEntry) {
+	// Seconds, like the other times links store
+	entry.At = entry.At.Truncate(time.Second)
+	rawEntry, _ := json.Marshal(entry) // Times and strings always encode
+	tx.AddToList(historyKey(slug), string(rawEntry))
+}
+
+// disabledAction is the entry for a link being disabled, or enabled again
+func disabledAction(disabled bool) HistoryAction {
+	if disabled {
+		return HistoryDisabled
+	}
+	return HistoryEnabled
+}
+
+// parseHistory reads a stored history, skipping entries that can't be read rather than hiding the rest
+func parseHistory(slug string, rawEntries []string) []HistoryEntry {
+	entries := make([]HistoryEntry, 0, len(rawEntries))
+	for _, rawEntry := range rawEntries {
+		var entry HistoryEntry
+		err := json.Unmarshal([]byte(rawEntry), &entry)
+		if err != nil {
+			logging.PrintErrStr("Skipping broken history entry of link " + slug + ": " + err.Error())
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// hadDestination reports whether a link pointed at url at some point in its history
+func hadDestination(entries []HistoryEntry, url string) bool {
+	return slices.ContainsFunc(entries, func(entry HistoryEntry) bool {
+		return entry.URL == url || entry.OldURL == url
+	})
+}
+
+// HistoryPage is what link-history.html renders
+type HistoryPage struct {
+	Link    Link
+	Entries []HistoryEntry
+}
+
+func renderHistory(w http.ResponseWriter, page HistoryPage) error {
+	tmpl, err := template.New("link-history.html").Funcs(linkTemplateFuncs).ParseFiles("static/link-history.html")
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "text/html")
+	return tmpl.Execute(w, page)
+}
+
+// writeHistoryCSV sends a link's history as a spreadsheet, oldest first like a log
+func writeHistoryCSV(w http.ResponseWriter, entries []HistoryEntry) error {
+	writer := csv.NewWriter(w)
+	err := writer.Write([]string{"at", "actor", "action", "slug", "old_slug", "url", "old_url"})
+	if err != nil {
+		return err
+	}
+	for _, entry := range slices.Backward(entries) {
+		err = writer.Write([]string{
+			entry.At.Format(time.RFC3339), entry.Actor, string(entry.Action),
+			entry.Slug, entry.OldSlug, entry.URL, entry.OldURL,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func epLinkHistory(db AdvancedDB, jwt JWTService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Verify user is authenticated
+		err := jwt.ReadAndValidateJWT(r)
+		if err != nil {
+			httpError(w, "JWT is invalid for getting link history", http.StatusForbidden, err)
+			return
+		}
+
+		linkSlug := r.URL.Query().Get("slug")
+		entries, err := db.GetLinkHistory(r.Context(), linkSlug)
+		if errors.Is(err, ErrLinkNotFound) {
+			httpError(w, "Link \""+linkSlug+"\" does not exist", http.StatusNotFound, err)
+			return
+		} else if err != nil {
+			httpError(w, "Failed to get history of link \""+linkSlug+"\"", http.StatusInternalServerError, err)
+			return
+		}
+
+		// Exports are downloaded as files, named after the link
+		switch format := r.URL.Query().Get("format"); format {
+		case "csv":
+			w.Header().Set("Content-Type", "text/csv")
+			setDownloadName(w, linkSlug+"-history.csv")
+			err = writeHistoryCSV(w, entries)
+			if err != nil {
+				logging.PrintErrStr("Failed to export history of link " + linkSlug + ": " + err.Error())
+			}
+			return
+		case "json":
+			setDownloadName(w, linkSlug+"-history.json")
+			writeJSON(w, http.StatusOK, entries)
+			return
+		case "":
+		default:
+			httpNewError(w, "Unknown history format \""+format+"\", use csv or json", http.StatusBadRequest)
+			return
+		}
+		if wantsJSON(r) {
+			writeJSON(w, http.StatusOK, entries)
+			return
+		}
+
+		link, err := db.GetLink(r.Context(), linkSlug) // For telling which destinations can be rolled back to
+		if errors.Is(err, ErrLinkNotFound) {
+			httpError(w, "Link \""+linkSlug+"\" is in the trash, restore it to see its history", http.StatusNotFound, err)
+			return
+		} else if err != nil {
+			httpError(w, "Failed to get link \""+linkSlug+"\"", http.StatusInternalServerError, err)
+			return
+		}
+		err = renderHistory(w, HistoryPage{Link: link, Entries: entries})
+		if err != nil {
+			httpError(w, "Failed to render link history", http.StatusInternalServerError, err)
+		}
+	}
+}
+
+func epRollbackLink(db AdvancedDB, jwt JWTService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Verify user is authenticated
+		err := jwt.ReadAndValidateJWT(r)
+		if err != nil {
+			httpError(w, "JWT is invalid for rolling back links", http.StatusForbidden, err)
+			return
+		}
+		if r.Method != http.MethodPost { // Only allow POST requests
+			httpNewError(w, "Method not allowed for rolling back links", http.StatusMethodNotAllowed)
+			return
+		}
+		if isJSONRequest(r) {
+			err = readJSONForm(r)
+			if err != nil {
+				httpError(w, "Failed to read rollback", http.StatusBadRequest, err)
+				return
+			}
+		}
+
+		linkSlug := r.FormValue("slug")
+		link, err := db.GetLink(r.Context(), linkSlug)
+		if errors.Is(err, ErrLinkNotFound) {
+			httpError(w, "Link \""+linkSlug+"\" does not exist", http.StatusNotFound, err)
+			return
+		} else if err != nil {
+			httpError(w, "Failed to get link \""+linkSlug+"\"", http.StatusInternalServerError, err)
+			return
+		}
+		// Allowed schemes can change, so old destinations are checked again
+		url, err := cleanDestination(link.Kind, r.FormValue("url"))
+		if err != nil {
+			httpError(w, "Failed to roll back link \""+linkSlug+"\"", http.StatusBadRequest, err)
+			return
+		}
+		err = db.RollbackLink(r.Context(), linkSlug, url, requestActor(r))
+		if errors.Is(err, ErrLinkNotFound) {
+			httpError(w, "Link \""+linkSlug+"\" does not exist", http.StatusNotFound, err)
+			return
+		} else if errors.Is(err, ErrUnknownDestination) {
+			httpError(w, "Link \""+linkSlug+"\" has never pointed to "+url, http.StatusBadRequest, err)
+			return
+		} else if err != nil {
+			httpError(w, "Failed to roll back link \""+linkSlug+"\" in database", http.StatusInternalServerError, err)
+			return
+		}
+		logging.Println("Rolled back link \"" + linkSlug + "\" to " + url)
+		renderLinksAfterChange(w, r, db, linkSlug)
+	}
+}
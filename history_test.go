@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"testing"
+	"time"
+)
+
+// historyActions lists what happened to a link, newest first
+func historyActions(t *testing.T, db DB, slug string) []HistoryAction {
+	t.Helper()
+	history, err := db.GetLinkHistory(t.Context(), slug)
+	if err != nil {
+		t.Fatalf("GetLinkHistory(%s): %v", slug, err)
+	}
+	actions := []HistoryAction{}
+	for _, entry := range history {
+		actions = append(actions, entry.Action)
+	}
+	return actions
+}
+
+func TestHistoryFollowsLink(t *testing.T) {
+	clock := newTestClock()
+	db := newTestDB(clock)
+	ctx := t.Context()
+	mustSetLink(t, db, Link{Slug: "docs", URL: "https://example.com/v1", CreatedBy: "dashboard"})
+
+	clock.Add(time.Minute)
+	err := db.UpdateLink(ctx, "docs", "guide", "api", func(link *Link) error {
+		link.URL = "https://example.com/v2"
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("UpdateLink: %v", err)
+	}
+	err = db.UpdateLink(ctx, "guide", "guide", "api", func(link *Link) error {
+		link.Title = "Untracked"
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("UpdateLink: %v", err)
+	}
+	err = db.TrashLink(ctx, "guide", "dashboard")
+	if err != nil {
+		t.Fatalf("TrashLink: %v", err)
+	}
+
+	// Trashed links keep their history, under their latest slug
+	want := []HistoryAction{HistoryDeleted, HistoryURLChanged, HistoryRenamed, HistoryCreated}
+	if actions := historyActions(t, db, "guide"); !slices.Equal(actions, want) {
+		t.Errorf("history = %v, want %v", actions, want)
+	}
+	if _, err := db.GetLinkHistory(ctx, "docs"); !errors.Is(err, ErrLinkNotFound) {
+		t.Errorf("history of the old slug error = %v, want ErrLinkNotFound", err)
+	}
+	history, _ := db.GetLinkHistory(ctx, "guide")
+	renamed := history[2]
+	if renamed.OldSlug != "docs" || renamed.Slug != "guide" || renamed.Actor != "api" || !renamed.At.Equal(clock.Now()) {
+		t.Errorf("rename entry = %+v", renamed)
+	}
+	if changed := history[1]; changed.OldURL != "https://example.com/v1" || changed.URL != "https://example.com/v2" {
+		t.Errorf("URL change entry = %+v", changed)
+	}
+
+	err = db.PurgeLink(ctx, "guide")
+	if err != nil {
+		t.Fatalf("PurgeLink: %v", err)
+	}
+	if _, err := db.GetLinkHistory(ctx, "guide"); !errors.Is(err, ErrLinkNotFound) {
+		t.Errorf("history after purging error = %v, want ErrLinkNotFound", err)
+	}
+}
+
+func TestRollbackLink(t *testing.T) {
+	clock := newTestClock()
+	db := newTestDB(clock)
+	ctx := t.Context()
+	mustSetLink(t, db, Link{Slug: "docs", URL: "https://example.com/v1"})
+	err := db.UpdateLink(ctx, "docs", "docs", "api", func(link *Link) error {
+		link.URL = "https://example.com/v2"
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("UpdateLink: %v", err)
+	}
+
+	err = db.RollbackLink(ctx, "docs", "https://example.org/never", "api")
+	if !errors.Is(err, ErrUnknownDestination) {
+		t.Errorf("rolling back to an unknown destination error = %v, want ErrUnknownDestination", err)
+	}
+	err = db.RollbackLink(ctx, "missing", "https://example.com/v1", "api")
+	if !errors.Is(err, ErrLinkNotFound) {
+		t.Errorf("rolling back a missing link error = %v, want ErrLinkNotFound", err)
+	}
+
+	clock.Add(time.Hour)
+	err = db.RollbackLink(ctx, "docs", "https://example.com/v1", "dashboard")
+	if err != nil {
+		t.Fatalf("RollbackLink: %v", err)
+	}
+	link := mustGetLink(t, db, "docs")
+	if link.URL != "https://example.com/v1" || !link.UpdatedAt.Equal(clock.Now()) {
+		t.Errorf("link points at %s, updated %v, want the old destination now", link.URL, link.UpdatedAt)
+	}
+	if got := search(t, db, "v1"); !slices.Equal(got, []string{"docs"}) {
+		t.Errorf("search v1 = %v, want the rolled back link", got)
+	}
+
+	// Rolling back to where the link already points changes nothing
+	err = db.RollbackLink(ctx, "docs", "https://example.com/v1", "dashboard")
+	if err != nil {
+		t.Fatalf("RollbackLink: %v", err)
+	}
+	want := []HistoryAction{HistoryRolledBack, HistoryURLChanged, HistoryCreated}
+	if actions := historyActions(t, db, "docs"); !slices.Equal(actions, want) {
+		t.Errorf("history = %v, want %v", actions, want)
+	}
+}
+
+func TestRollbackLinkRechecksDestination(t *testing.T) {
+	clock := newTestClock()
+	db, jwt := newTestDB(clock), newTestJWT(clock)
+	handler := epRollbackLink(db, jwt)
+	ctx := t.Context()
+	// Saved before javascript: links were refused
+	db.basicDB.SetHash(ctx, "docs", map[string]string{"url": "https://example.com/v2", "clicks": "0", "redirect_code": "302"})
+	db.basicDB.AddToList(ctx, "links", "docs")
+	db.basicDB.Transaction(ctx, nil, func(tx Tx) error {
+		recordHistory(tx, "docs", HistoryEntry{At: clock.Now(), Action: HistoryURLChanged, Slug: "docs", URL: "https://example.com/v2", OldURL: "javascript:alert(1)"})
+		return nil
+	})
+
+	w := postForm(t, jwt, handler, "/api/rollback-link", url.Values{"slug": {"docs"}, "url": {"javascript:alert(1)"}})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("rolling back to a script: status = %d, want 400", w.Code)
+	}
+	if link := mustGetLink(t, db, "docs"); link.URL != "https://example.com/v2" {
+		t.Errorf("url = %s, want it left alone", link.URL)
+	}
+}
+
+func TestLinkHistoryExports(t *testing.T) {
+	clock := newTestClock()
+	db, jwt := newTestDB(clock), newTestJWT(clock)
+	handler := epLinkHistory(db, jwt)
+	mustSetLink(t, db, Link{Slug: "docs", URL: "https://example.com/v1", CreatedBy: "api"})
+	clock.Add(time.Minute)
+	_, err := db.SetLinksDisabled(t.Context(), []string{"docs"}, true, "dashboard")
+	if err != nil {
+		t.Fatalf("SetLinksDisabled: %v", err)
+	}
+	get := func(target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, loggedIn(t, jwt, httptest.NewRequest(http.MethodGet, target, nil)))
+		return w
+	}
+
+	w := get("/api/link-history?slug=docs&format=csv")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "text/csv" {
+		t.Fatalf("CSV status = %d, type %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	rows, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil || len(rows) != 3 {
+		t.Fatalf("CSV rows = %v, %v, want a header and two entries", rows, err)
+	}
+	if rows[1][2] != string(HistoryCreated) || rows[2][2] != string(HistoryDisabled) || rows[2][1] != "dashboard" {
+		t.Errorf("CSV rows = %v, want the entries oldest first", rows[1:])
+	}
+
+	w = get("/api/link-history?slug=docs&format=json")
+	var entries []HistoryEntry
+	if err := json.Unmarshal(w.Body.Bytes(), &entries); err != nil || len(entries) != 2 || entries[0].Action != HistoryDisabled {
+		t.Errorf("JSON = %s, want the entries newest first", w.Body)
+	}
+
+	if w = get("/api/link-history?slug=docs&format=xml"); w.Code != http.StatusBadRequest {
+		t.Errorf("unknown format: status = %d, want 400", w.Code)
+	}
+	if w = get("/api/link-history?slug=missing"); w.Code != http.StatusNotFound {
+		t.Errorf("missing link: status = %d, want 404", w.Code)
+	}
+}
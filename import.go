@@ -0,0 +1,490 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"nyooom/logging"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	maxImportSize          = 5 << 20 // Plenty for thousands of links
+	maxImportRows          = 5000
+	maxImportRenameAttempt = 100 // How far "docs-2", "docs-3"… goes looking for a free slug
+)
+
+// Who imported links are created by, in place of requestActor
+const importActor = "import"
+
+// importFields are the columns an import can set, named like the fields of the dashboard's forms.
+// Anything else, like the clicks in an export from /api/get-links, is ignored.
+var importFields = []string{
+	"slug", "url", "kind", "title", "notes", "tags",
+	"expires_at", "not_before", "max_clicks", "redirect_code",
+	"passthrough", "query_merge", "fallback_url", "password", "disabled", "schedule",
+}
+
+// Columns that are checkboxes on the dashboard, which spreadsheets write as words
+var importBoolFields = []string{"passthrough", "disabled"}
+
+// readImportSchedule adds a schedule, as a list of {"at": ..., "url": ...} objects like /api/get-links exports,
+// to a row's form values as the schedule_at and schedule_url fields the dashboard sends. Null or an empty list clears it.
+func readImportSchedule(form url.Values, schedule any) error {
+	changes, ok := schedule.([]any)
+	if schedule != nil && !ok {
+		return errors.New("Invalid schedule: expected a list of changes with at and url")
+	}
+	form["schedule_at"], form["schedule_url"] = []string{}, []string{}
+	for _, change := range changes {
+		fields, _ := change.(map[string]any)
+		at, hasAt := fields["at"].(string)
+		destination, hasURL := fields["url"].(string)
+		if !hasAt || !hasURL {
+			return errors.New("Invalid schedule: every change needs an at time and a url")
+		}
+		form.Add("schedule_at", at)
+		form.Add("schedule_url", destination)
+	}
+	return nil
+}
+
+// ImportConflict is what happens to a row whose slug is already taken
+type ImportConflict string
+
+const (
+	ImportSkip      ImportConflict = "skip"      // Leave the existing link alone
+	ImportOverwrite ImportConflict = "overwrite" // Change the existing link's settings to the row's, keeping its clicks, history and anything the file leaves out
+	ImportRename    ImportConflict = "rename"    // Import the row as "slug-2", or the next free number
+)
+
+// parseImportConflict reads a conflict policy, where an empty string means skipping
+func parseImportConflict(raw string) (ImportConflict, error) {
+	switch conflict := ImportConflict(raw); conflict {
+	case "":
+		return ImportSkip, nil
+	case ImportSkip, ImportOverwrite, ImportRename:
+		return conflict, nil
+	default:
+		return "", errors.New(raw + " is not a conflict policy, use skip, overwrite or rename")
+	}
+}
+
+// ImportStatus is what happened to a row, or would have in a dry run
+type ImportStatus string
+
+const (
+	ImportCreated ImportStatus = "created"
+	ImportUpdated ImportStatus = "updated"
+	ImportRenamed ImportStatus = "renamed" // Created under a different slug
+	ImportSkipped ImportStatus = "skipped"
+	ImportFailed  ImportStatus = "failed"
+)
+
+// ImportRow is the outcome of one row of an import
+type ImportRow struct {
+	Row         int          `json:"row"` // Line of a CSV file, or position in a JSON list, counting from 1
+	Slug        string       `json:"slug"`
+	Status      ImportStatus `json:"status"`
+	Error       string       `json:"error,omitempty"` // Why the row failed or was skipped
+	RenamedFrom string       `json:"renamed_from,omitempty"`
+}
+
+// ImportReport lists what happened to every row of an import
+type ImportReport struct {
+	DryRun   bool                 `json:"dry_run"`
+	Conflict ImportConflict       `json:"conflict"`
+	Summary  map[ImportStatus]int `json:"summary"`
+	Rows     []ImportRow          `json:"rows"`
+}
+
+// Count is how many rows ended up with a status
+func (report ImportReport) Count(status ImportStatus) int {
+	return report.Summary[status]
+}
+
+// Changed reports whether the import created or updated any links
+func (report ImportReport) Changed() bool {
+	return !report.DryRun && report.Count(ImportCreated)+report.Count(ImportUpdated)+report.Count(ImportRenamed) > 0
+}
+
+// importRecord is one row of an import file, as form values
+type importRecord struct {
+	Row  int
+	Form url.Values
+	Err  error // Set when the row couldn't be read at all
+}
+
+// parseImportCSV reads a spreadsheet whose first line names its columns, like "slug,url,tags"
+func parseImportCSV(file io.Reader) ([]importRecord, error) {
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1 // Short rows just leave the last columns empty
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, errors.New("Could not read CSV: file is empty")
+	} else if err != nil {
+		return nil, errors.New("Could not read CSV: " + err.Error())
+	}
+	for i, column := range header {
+		header[i] = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(column, "\ufeff"))) // Spreadsheets can start with a byte order mark
+	}
+	if !slices.Contains(header, "slug") || !slices.Contains(header, "url") {
+		return nil, errors.New("Could not read CSV: the first line needs slug and url columns")
+	}
+
+	records := []importRecord{}
+	for {
+		cells, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return records, nil
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if !errors.As(err, &parseErr) { // Only broken quoting can be skipped past
+				return nil, errors.New("Could not read CSV: " + err.Error())
+			}
+			records = append(records, importRecord{Row: parseErr.StartLine, Err: err})
+			continue
+		}
+		line, _ := reader.FieldPos(0)
+		record := importRecord{Row: line, Form: url.Values{}}
+		for i, cell := range cells {
+			if i >= len(header) || !slices.Contains(importFields, header[i]) {
+				continue
+			}
+			if header[i] == "schedule" { // Written as JSON, since a change has both a time and a URL
+				var schedule any
+				if strings.TrimSpace(cell) != "" {
+					err = json.Unmarshal([]byte(cell), &schedule)
+					if err != nil {
+						record.Err = errors.New("Invalid schedule: " + err.Error())
+						break
+					}
+				}
+				record.Err = readImportSchedule(record.Form, schedule)
+				continue
+			}
+			if slices.Contains(importBoolFields, header[i]) {
+				switch strings.ToLower(strings.TrimSpace(cell)) {
+				case "true", "yes", "on", "1":
+					cell = "on"
+				default:
+					cell = ""
+				}
+			}
+			record.Form.Set(header[i], cell)
+		}
+		records = append(records, record)
+	}
+}
+
+// parseImportJSON reads a list of objects with the same fields as the JSON API, or the output of /api/get-links
+func parseImportJSON(file io.Reader) ([]importRecord, error) {
+	var document any
+	err := json.NewDecoder(file).Decode(&document)
+	if err != nil {
+		return nil, errors.New("Could not read JSON: " + err.Error())
+	}
+	items, ok := document.([]any)
+	if page, isObject := document.(map[string]any); isObject {
+		items, ok = page["links"].([]any)
+	}
+	if !ok {
+		return nil, errors.New("Could not read JSON: expected a list of links, or an object with a links list")
+	}
+
+	records := make([]importRecord, 0, len(items))
+	for i, item := range items {
+		record := importRecord{Row: i + 1}
+		fields, ok := item.(map[string]any)
+		if !ok {
+			record.Err = errors.New("row is not an object")
+			records = append(records, record)
+			continue
+		}
+		known := map[string]any{}
+		for _, field := range importFields {
+			if value, ok := fields[field]; ok && field != "schedule" {
+				known[field] = value
+			}
+		}
+		record.Form, record.Err = jsonFormValues(known)
+		if schedule, ok := fields["schedule"]; ok && record.Err == nil {
+			record.Err = readImportSchedule(record.Form, schedule)
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// readImport reads the rows and options of an import, sent either as a dashboard upload or as the request body
+func readImport(r *http.Request) (records []importRecord, dryRun bool, conflict ImportConflict, err error) {
+	var file io.Reader
+	var format string
+	r.Body = http.MaxBytesReader(nil, r.Body, maxImportSize)
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		err = r.ParseMultipartForm(maxImportSize)
+		if err != nil {
+			return nil, false, "", errors.New("Could not read upload: " + err.Error())
+		}
+		upload, header, err := r.FormFile("file")
+		if err != nil {
+			return nil, false, "", errors.New("Could not read upload: " + err.Error())
+		}
+		defer upload.Close()
+		file = upload
+		format = strings.TrimPrefix(strings.ToLower(filepath.Ext(header.Filename)), ".")
+	} else { // Scripts can send the file as is, with options in the query
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return nil, false, "", errors.New("Could not read import: " + err.Error())
+		}
+		file = bytes.NewReader(body)
+		switch mediaType {
+		case "text/csv":
+			format = "csv"
+		case "application/json":
+			format = "json"
+		}
+	}
+
+	dryRun = r.FormValue("dry_run") == "on" || r.FormValue("dry_run") == "true"
+	conflict, err = parseImportConflict(r.FormValue("conflict"))
+	if err != nil {
+		return nil, false, "", errors.New("Could not read import: " + err.Error())
+	}
+	switch format {
+	case "csv":
+		records, err = parseImportCSV(file)
+	case "json":
+		records, err = parseImportJSON(file)
+	default:
+		err = errors.New("Could not read import: send a .csv or .json file")
+	}
+	if err != nil {
+		return nil, false, "", err
+	}
+	if len(records) > maxImportRows {
+		return nil, false, "", errors.New("Could not read import: at most " + strconv.Itoa(maxImportRows) + " links can be imported at once")
+	}
+	return records, dryRun, conflict, nil
+}
+
+// importer brings rows into the database one at a time, remembering the slugs earlier rows took
+type importer struct {
+	ctx      context.Context
+	db       AdvancedDB
+	dryRun   bool
+	conflict ImportConflict
+	claimed  map[string]bool // Slugs taken by earlier rows, which a dry run doesn't write
+	now      time.Time       // When the import started, so every row drops the same past scheduled changes
+}
+
+// claimKey is how a slug is remembered, so case variants collide when slugs are case-insensitive
+func claimKey(slug string) string {
+	if caseInsensitiveSlugs {
+		return strings.ToLower(slug)
+	}
+	return slug
+}
+
+// isFree reports whether neither the database nor an earlier row has a slug
+func (imp importer) isFree(slug string) (bool, error) {
+	if imp.claimed[claimKey(slug)] {
+		return false, nil
+	}
+	return imp.db.IsSlugFree(imp.ctx, slug)
+}
+
+// importRecord validates a row like the dashboard's create form, then creates, overwrites, renames or skips it
+func (imp importer) importRecord(record importRecord) ImportRow {
+	result := ImportRow{Row: record.Row, Slug: record.Form.Get("slug")}
+	fail := func(err error) ImportRow {
+		result.Status, result.Error = ImportFailed, err.Error()
+		return result
+	}
+	if record.Err != nil {
+		return fail(record.Err)
+	}
+	if strings.TrimSpace(result.Slug) == "" {
+		return fail(errors.New("Invalid slug: slug is empty"))
+	}
+
+	kind, err := parseLinkKind(record.Form.Get("kind"))
+	if err != nil {
+		return fail(err)
+	}
+	link, err := newLink(result.Slug, record.Form.Get("url"), kind)
+	if err != nil {
+		return fail(err)
+	}
+	err = readLinkOptions(record.Form, &link, imp.now)
+	if err != nil {
+		return fail(err)
+	}
+	if imp.dryRun { // Hashing is slow enough to add up over a whole file, and a dry run stores nothing
+		err = checkLinkPassword(record.Form)
+	} else {
+		link.PasswordHash, err = readLinkPassword(record.Form)
+	}
+	if err != nil {
+		return fail(err)
+	}
+	link.Disabled = record.Form.Get("disabled") == "on"
+	link.CreatedBy = importActor
+
+	free, err := imp.isFree(link.Slug)
+	if err != nil {
+		return fail(err)
+	}
+	switch {
+	case free:
+		result.Status = ImportCreated
+	case imp.conflict == ImportSkip:
+		result.Status, result.Error = ImportSkipped, "slug is already taken"
+		return result
+	case imp.conflict == ImportOverwrite:
+		return imp.overwrite(result, link, record.Form)
+	case imp.conflict == ImportRename:
+		result.RenamedFrom, result.Status = link.Slug, ImportRenamed
+		link.Slug, err = imp.freeSlug(link.Slug)
+		if err != nil {
+			return fail(err)
+		}
+		result.Slug = link.Slug
+	}
+
+	if !imp.dryRun {
+		err = imp.db.SetLink(imp.ctx, link)
+		if err != nil {
+			return fail(err)
+		}
+	}
+	imp.claimed[claimKey(link.Slug)] = true
+	return result
+}
+
+// overwrite changes the settings of the link at a taken slug to the row's, the same way editing it through the API would.
+// Settings the file has no column for are kept, and link is the row as it was checked.
+func (imp importer) overwrite(result ImportRow, link Link, form url.Values) ImportRow {
+	if imp.claimed[claimKey(link.Slug)] {
+		result.Status, result.Error = ImportFailed, "slug is used by an earlier row"
+		return result
+	}
+	_, err := imp.db.GetLink(imp.ctx, link.Slug)
+	if errors.Is(err, ErrLinkNotFound) {
+		result.Status, result.Error = ImportFailed, "slug is taken by an alias, a case variant or a link in the trash, which can't be overwritten"
+		return result
+	} else if err != nil {
+		result.Status, result.Error = ImportFailed, err.Error()
+		return result
+	}
+
+	if !imp.dryRun {
+		err = imp.db.UpdateLink(imp.ctx, link.Slug, link.Slug, importActor, func(stored *Link) error {
+			err := readLinkChanges(form, stored, imp.now)
+			if err != nil {
+				return err
+			}
+			if link.IsProtected() { // Keep the current password unless the row has a new one
+				stored.PasswordHash = link.PasswordHash
+			}
+			if form.Has("disabled") {
+				stored.Disabled = link.Disabled
+			}
+			return nil
+		})
+		if err != nil {
+			result.Status, result.Error = ImportFailed, err.Error()
+			return result
+		}
+	}
+	imp.claimed[claimKey(link.Slug)] = true
+	result.Status = ImportUpdated
+	return result
+}
+
+// freeSlug finds the first of "slug-2", "slug-3"… that nothing has taken
+func (imp importer) freeSlug(slug string) (string, error) {
+	for number := 2; number < maxImportRenameAttempt+2; number++ {
+		candidate := slug + "-" + strconv.Itoa(number)
+		if validateSlug(candidate) != nil {
+			continue
+		}
+		free, err := imp.isFree(candidate)
+		if err != nil {
+			return "", err
+		}
+		if free {
+			return candidate, nil
+		}
+	}
+	return "", errors.New("Could not find a free slug like " + slug + "-2 to rename to")
+}
+
+func renderImportReport(w http.ResponseWriter, report ImportReport) error {
+	tmpl, err := template.New("import-report.html").ParseFiles("static/import-report.html")
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "text/html")
+	return tmpl.Execute(w, report)
+}
+
+func epImportLinks(db AdvancedDB, jwt JWTService, clock Clock) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Verify user is authenticated
+		err := jwt.ReadAndValidateJWT(r)
+		if err != nil {
+			httpError(w, "JWT is invalid for importing links", http.StatusForbidden, err)
+			return
+		}
+		if r.Method != http.MethodPost { // Only allow POST requests
+			httpNewError(w, "Method not allowed for importing links", http.StatusMethodNotAllowed)
+			return
+		}
+
+		records, dryRun, conflict, err := readImport(r)
+		if err != nil { // Problems with the file are for the user to fix, so they're told what's wrong
+			httpNewError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		imp := importer{ctx: r.Context(), db: db, dryRun: dryRun, conflict: conflict, claimed: map[string]bool{}, now: clock.Now()}
+		report := ImportReport{DryRun: dryRun, Conflict: conflict, Summary: map[ImportStatus]int{}, Rows: make([]ImportRow, 0, len(records))}
+		for _, record := range records {
+			row := imp.importRecord(record)
+			report.Summary[row.Status]++
+			report.Rows = append(report.Rows, row)
+		}
+		if dryRun {
+			logging.Println("Checked " + strconv.Itoa(len(records)) + " links for import")
+		} else {
+			logging.Println("Imported " + strconv.Itoa(len(records)) + " links, " + strconv.Itoa(report.Summary[ImportFailed]) + " failed")
+		}
+
+		if wantsJSON(r) {
+			writeJSON(w, http.StatusOK, report)
+			return
+		}
+		if report.Changed() {
+			w.Header().Set("HX-Trigger", "refreshLinks")
+		}
+		err = renderImportReport(w, report)
+		if err != nil {
+			httpError(w, "Failed to render import report", http.StatusInternalServerError, err)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// importFile sends a file to the import endpoint the way scripts do, and reads the report
+func importFile(t *testing.T, jwt JWTService, handler http.Handler, query string, contentType string, body string) ImportReport {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodPost, "/api/import-links?"+query, strings.NewReader(body))
+	r.Header.Set("Content-Type", contentType)
+	r.Header.Set("Accept", "application/json")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, loggedIn(t, jwt, r))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+	}
+	var report ImportReport
+	err := json.Unmarshal(w.Body.Bytes(), &report)
+	if err != nil {
+		t.Fatalf("response isn't a report: %v", err)
+	}
+	return report
+}
+
+func rowStatuses(report ImportReport) []ImportStatus {
+	statuses := make([]ImportStatus, len(report.Rows))
+	for i, row := range report.Rows {
+		statuses[i] = row.Status
+	}
+	return statuses
+}
+
+func TestImportConflicts(t *testing.T) {
+	const file = "slug,url\ndocs,https://example.org/docs\nnew,https://example.net\n"
+	tests := []struct {
+		conflict ImportConflict
+		want     []ImportStatus
+		url      string // Of the existing link afterwards
+	}{
+		{ImportSkip, []ImportStatus{ImportSkipped, ImportCreated}, "https://example.com/docs"},
+		{ImportOverwrite, []ImportStatus{ImportUpdated, ImportCreated}, "https://example.org/docs"},
+		{ImportRename, []ImportStatus{ImportRenamed, ImportCreated}, "https://example.com/docs"},
+	}
+	for _, test := range tests {
+		t.Run(string(test.conflict), func(t *testing.T) {
+			clock := newTestClock()
+			db, jwt := newTestDB(clock), newTestJWT(clock)
+			handler := epImportLinks(db, jwt, clock)
+			passwordHash, _ := hashPassword("secret")
+			mustSetLink(t, db, Link{Slug: "docs", URL: "https://example.com/docs", Title: "Docs", Tags: []string{"work"}, PasswordHash: string(passwordHash)})
+
+			report := importFile(t, jwt, handler, "conflict="+string(test.conflict), "text/csv", file)
+			if !slices.Equal(rowStatuses(report), test.want) {
+				t.Errorf("statuses = %v, want %v", rowStatuses(report), test.want)
+			}
+
+			link := mustGetLink(t, db, "docs")
+			if link.URL != test.url {
+				t.Errorf("url = %q, want %q", link.URL, test.url)
+			}
+			// Columns the file doesn't have are kept, even when overwriting
+			if link.Title != "Docs" || !slices.Equal(link.Tags, []string{"work"}) || link.PasswordHash != string(passwordHash) {
+				t.Errorf("title %q, tags %v or password changed, want them kept", link.Title, link.Tags)
+			}
+			mustGetLink(t, db, "new")
+
+			if test.conflict == ImportRename {
+				row := report.Rows[0]
+				if row.Slug != "docs-2" || row.RenamedFrom != "docs" {
+					t.Errorf("renamed to %q from %q, want docs-2 from docs", row.Slug, row.RenamedFrom)
+				}
+				if renamed := mustGetLink(t, db, "docs-2"); renamed.URL != "https://example.org/docs" {
+					t.Errorf("renamed url = %q, want the row's", renamed.URL)
+				}
+			}
+		})
+	}
+}
+
+func TestImportOverwriteDisables(t *testing.T) {
+	clock := newTestClock()
+	db, jwt := newTestDB(clock), newTestJWT(clock)
+	handler := epImportLinks(db, jwt, clock)
+	mustSetLink(t, db, Link{Slug: "docs", URL: "https://example.com/docs"})
+	mustSetLink(t, db, Link{Slug: "paused", URL: "https://example.com/paused", Disabled: true})
+
+	clock.Add(time.Minute)
+	report := importFile(t, jwt, handler, "conflict=overwrite", "text/csv", "slug,url,disabled\ndocs,https://example.org/docs,true\npaused,https://example.com/paused,\n")
+	if !slices.Equal(rowStatuses(report), []ImportStatus{ImportUpdated, ImportUpdated}) {
+		t.Fatalf("statuses = %v, want both updated", rowStatuses(report))
+	}
+
+	link := mustGetLink(t, db, "docs")
+	if !link.Disabled || link.URL != "https://example.org/docs" {
+		t.Errorf("disabled %v, url %s, want the row's", link.Disabled, link.URL)
+	}
+	want := []HistoryAction{HistoryDisabled, HistoryURLChanged, HistoryCreated}
+	if actions := historyActions(t, db, "docs"); !slices.Equal(actions, want) {
+		t.Errorf("history = %v, want %v", actions, want)
+	}
+	history, _ := db.GetLinkHistory(t.Context(), "docs")
+	if history[0].Actor != importActor || !history[0].At.Equal(clock.Now()) {
+		t.Errorf("disabled entry = %+v, want it made by the import", history[0])
+	}
+
+	// An empty cell enables the link, without touching what else matches
+	if mustGetLink(t, db, "paused").Disabled {
+		t.Error("paused is still disabled")
+	}
+	want = []HistoryAction{HistoryEnabled, HistoryCreated}
+	if actions := historyActions(t, db, "paused"); !slices.Equal(actions, want) {
+		t.Errorf("paused history = %v, want %v", actions, want)
+	}
+}
+
+func TestImportDryRunWritesNothing(t *testing.T) {
+	clock := newTestClock()
+	db, jwt := newTestDB(clock), newTestJWT(clock)
+	handler := epImportLinks(db, jwt, clock)
+	mustSetLink(t, db, Link{Slug: "docs", URL: "https://example.com/docs"})
+
+	file := "slug,url,password\n" +
+		"docs,https://example.org/docs,\n" +
+		"locked,https://example.net,secret\n" +
+		"long,https://example.net," + strings.Repeat("x", maxPasswordLength+1) + "\n" +
+		"locked,https://example.net/again,\n"
+	report := importFile(t, jwt, handler, "dry_run=true&conflict=overwrite", "text/csv", file)
+	want := []ImportStatus{ImportUpdated, ImportCreated, ImportFailed, ImportFailed}
+	if !report.DryRun || !slices.Equal(rowStatuses(report), want) {
+		t.Errorf("dry run %v with statuses %v, want %v", report.DryRun, rowStatuses(report), want)
+	}
+
+	if link := mustGetLink(t, db, "docs"); link.URL != "https://example.com/docs" {
+		t.Errorf("url = %q, want the dry run to leave it alone", link.URL)
+	}
+	for _, slug := range []string{"locked", "long"} {
+		if _, err := db.GetLink(t.Context(), slug); err == nil {
+			t.Errorf("dry run created %s", slug)
+		}
+	}
+}
+
+func TestImportExportedLinks(t *testing.T) {
+	clock := newTestClock()
+	db, jwt := newTestDB(clock), newTestJWT(clock)
+	expiresAt, changeAt := clock.Now().Add(48*time.Hour).Truncate(time.Second), clock.Now().Add(24*time.Hour)
+	exported := Link{
+		Slug:        "launch",
+		URL:         "https://example.com/soon",
+		Title:       "Launch",
+		Tags:        []string{"events"},
+		ExpiresAt:   &expiresAt,
+		MaxClicks:   100,
+		Schedule:    []ScheduledURL{{At: changeAt, URL: "https://example.com/live"}},
+		Passthrough: true,
+		Disabled:    true,
+	}
+	mustSetLink(t, db, exported)
+	page, err := db.GetLinks(t.Context(), defaultLinkQuery())
+	if err != nil {
+		t.Fatalf("GetLinks: %v", err)
+	}
+	export, _ := json.Marshal(page)
+
+	// Into a fresh database, as when moving to a new server
+	imported := newTestDB(clock)
+	report := importFile(t, jwt, epImportLinks(imported, jwt, clock), "", "application/json", string(export))
+	if !slices.Equal(rowStatuses(report), []ImportStatus{ImportCreated}) {
+		t.Fatalf("statuses = %v, want the link created: %+v", rowStatuses(report), report.Rows)
+	}
+	link := mustGetLink(t, imported, "launch")
+	if link.URL != exported.URL || link.Title != exported.Title || !slices.Equal(link.Tags, exported.Tags) {
+		t.Errorf("link = %+v, want the exported one", link)
+	}
+	if link.ExpiresAt == nil || !link.ExpiresAt.Equal(expiresAt) || link.MaxClicks != 100 || !link.Passthrough || !link.Disabled {
+		t.Errorf("expiry %v, click limit %d, passthrough %v, disabled %v, want the exported settings", link.ExpiresAt, link.MaxClicks, link.Passthrough, link.Disabled)
+	}
+	if !slices.Equal(link.Schedule, exported.Schedule) {
+		t.Errorf("schedule = %v, want %v", link.Schedule, exported.Schedule)
+	}
+
+	// Spreadsheets write the schedule as JSON in a single cell
+	cell := `"[{""at"": ""` + changeAt.Format(time.RFC3339) + `"", ""url"": ""https://example.com/live""}]"`
+	file := "slug,url,schedule\nlater,https://example.com," + cell + "\nbroken,https://example.com,not json\n"
+	report = importFile(t, jwt, epImportLinks(imported, jwt, clock), "", "text/csv", file)
+	if !slices.Equal(rowStatuses(report), []ImportStatus{ImportCreated, ImportFailed}) {
+		t.Fatalf("statuses = %v, want the good schedule imported: %+v", rowStatuses(report), report.Rows)
+	}
+	if link := mustGetLink(t, imported, "later"); !slices.Equal(link.Schedule, exported.Schedule) {
+		t.Errorf("schedule = %v, want %v", link.Schedule, exported.Schedule)
+	}
+}
@@ -2,8 +2,12 @@ package main
 
 import (
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
 	"errors"
 	"net/http"
+	"net/url"
 	"nyooom/logging"
 	"os"
 	"time"
@@ -13,42 +17,56 @@ import (
 
 type Claims struct {
 	jwt.RegisteredClaims
+	PasswordFingerprint string `json:"pwd,omitempty"` // Which password an unlock token was given for, from passwordFingerprint
 }
 
 // TimeFunc allows mocking time in tests
 type TimeFunc func() time.Time
 
 type JWTService struct {
-	secret        []byte
-	timeFunc      TimeFunc
-	cookieName    string
-	loginDuration time.Duration
+	secret           []byte
+	timeFunc         TimeFunc
+	cookieName       string
+	loginDuration    time.Duration
+	unlockCookieName string
+	unlockDuration   time.Duration
 }
 
+const (
+	sessionSubject = "Session Token"
+	unlockSubject  = "Unlock Link " // Followed by the slug of the unlocked link
+)
+
 func NewJWTService(secret string, timeGenerator TimeFunc) JWTService {
 	return JWTService{
-		secret:        []byte(secret),
-		timeFunc:      timeGenerator,
-		cookieName:    "nyooom-session-token",
-		loginDuration: time.Hour*24*6 + time.Hour*12, // 6.5 days
+		secret:           []byte(secret),
+		timeFunc:         timeGenerator,
+		cookieName:       "nyooom-session-token",
+		loginDuration:    time.Hour*24*6 + time.Hour*12, // 6.5 days
+		unlockCookieName: "nyooom-unlock-token",
+		unlockDuration:   time.Hour,
 	}
 }
 
 func (s *JWTService) GenerateJWT(duration time.Duration) (string, error) {
+	return s.generateToken(sessionSubject, "", duration)
+}
+
+func (s *JWTService) generateToken(subject string, passwordFingerprint string, duration time.Duration) (string, error) {
 	now := s.timeFunc()
-	claims := Claims{}
+	claims := Claims{PasswordFingerprint: passwordFingerprint}
 	claims.ExpiresAt = jwt.NewNumericDate(now.Add(duration))
 	claims.IssuedAt = jwt.NewNumericDate(now)
 	claims.NotBefore = jwt.NewNumericDate(now)
 	claims.Issuer = "Backend API"
-	claims.Subject = "Session Token"
+	claims.Subject = subject
 	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(s.secret)
 }
 
 func (s *JWTService) ValidateJWT(tokenString string) (*Claims, bool) {
 	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (any, error) {
 		return s.secret, nil
-	})
+	}, jwt.WithTimeFunc(s.timeFunc))
 	if err != nil {
 		return nil, false
 	}
@@ -66,8 +84,8 @@ func (s *JWTService) ReadAndValidateJWT(r *http.Request) error {
 	} else if cookie.Value == "" {
 		return errors.New("JWT is empty")
 	}
-	_, ok := s.ValidateJWT(cookie.Value)
-	if !ok {
+	claims, ok := s.ValidateJWT(cookie.Value)
+	if !ok || claims.Subject != sessionSubject { // Unlock tokens share the secret, but aren't logins
 		return errors.New("JWT is invalid")
 	}
 	return nil
@@ -111,8 +129,50 @@ func (s *JWTService) setJWT(w http.ResponseWriter) error {
 		HttpOnly: false,
 		Secure:   false,
 		SameSite: http.SameSiteStrictMode,
-		Expires:  time.Now().Add(s.loginDuration),
+		Expires:  s.timeFunc().Add(s.loginDuration),
 		Path:     "/",
 	})
 	return nil
 }
+
+// passwordFingerprint identifies a link's password hash without giving it away, since anyone holding a token can read it
+func (s *JWTService) passwordFingerprint(passwordHash string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(passwordHash))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// setUnlockJWT remembers that a visitor entered the password of a link, for a short while.
+// The token only unlocks the link at that slug with that password, so renaming the link,
+// changing its password, or a new link taking its slug all lock it again.
+// The cookie is kept to slug, the path the visitor used, which can be an alias of the link.
+func (s *JWTService) setUnlockJWT(w http.ResponseWriter, slug string, link Link) error {
+	token, err := s.generateToken(unlockSubject+link.Slug, s.passwordFingerprint(link.PasswordHash), s.unlockDuration)
+	if err != nil {
+		return err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     s.unlockCookieName,
+		Value:    token,
+		HttpOnly: true,
+		Secure:   false,
+		SameSite: http.SameSiteLaxMode, // Unlocked links are usually opened from elsewhere
+		Expires:  s.timeFunc().Add(s.unlockDuration),
+		Path:     "/" + url.PathEscape(slug), // Each link keeps its own unlock
+	})
+	return nil
+}
+
+// IsUnlocked checks whether the request carries a valid unlock for a link and its current password
+func (s *JWTService) IsUnlocked(r *http.Request, link Link) bool {
+	cookie, err := r.Cookie(s.unlockCookieName)
+	if err != nil || cookie.Value == "" {
+		return false
+	}
+	claims, ok := s.ValidateJWT(cookie.Value)
+	if !ok || claims.Subject != unlockSubject+link.Slug {
+		return false
+	}
+	return hmac.Equal([]byte(claims.PasswordFingerprint), []byte(s.passwordFingerprint(link.PasswordHash)))
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// unlockCookie unlocks a link the way entering its password does
+func unlockCookie(t *testing.T, jwt JWTService, link Link) *http.Cookie {
+	t.Helper()
+	w := httptest.NewRecorder()
+	err := jwt.setUnlockJWT(w, link.Slug, link)
+	if err != nil {
+		t.Fatalf("setUnlockJWT: %v", err)
+	}
+	return w.Result().Cookies()[0]
+}
+
+func TestUnlockTokensAreNotSessions(t *testing.T) {
+	clock := newTestClock()
+	jwt := newTestJWT(clock)
+	link := Link{Slug: "secret", PasswordHash: "hash"}
+
+	r := httptest.NewRequest(http.MethodGet, "/api/get-links", nil)
+	r.AddCookie(&http.Cookie{Name: jwt.cookieName, Value: unlockCookie(t, jwt, link).Value})
+	if err := jwt.ReadAndValidateJWT(r); err == nil {
+		t.Error("an unlock token logged in to the dashboard")
+	}
+
+	session, _ := jwt.GenerateJWT(time.Hour)
+	r = httptest.NewRequest(http.MethodGet, "/secret", nil)
+	r.AddCookie(&http.Cookie{Name: jwt.unlockCookieName, Value: session})
+	if jwt.IsUnlocked(r, link) {
+		t.Error("a session token unlocked a link")
+	}
+}
+
+func TestUnlockTokensOnlyUnlockTheirLink(t *testing.T) {
+	clock := newTestClock()
+	jwt := newTestJWT(clock)
+	link := Link{Slug: "secret", PasswordHash: "hash"}
+	cookie := unlockCookie(t, jwt, link)
+	if cookie.Path != "/secret" || !cookie.HttpOnly {
+		t.Errorf("cookie path %q, HttpOnly %v, want it kept to the link and away from scripts", cookie.Path, cookie.HttpOnly)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/other", nil)
+	r.AddCookie(cookie)
+	if jwt.IsUnlocked(r, Link{Slug: "other", PasswordHash: "hash"}) {
+		t.Error("unlocking one link unlocked another")
+	}
+	r = httptest.NewRequest(http.MethodGet, "/secret", nil)
+	r.AddCookie(cookie)
+	if !jwt.IsUnlocked(r, link) {
+		t.Fatal("unlock token didn't unlock its link")
+	}
+
+	clock.Add(jwt.unlockDuration)
+	if jwt.IsUnlocked(r, link) {
+		t.Error("unlock token still works after it expired")
+	}
+}
+
+func TestRedirectAsksForPassword(t *testing.T) {
+	clock := newTestClock()
+	db, jwt := newTestDB(clock), newTestJWT(clock)
+	handler := epRedirectWithClock(db, jwt, clock, RedirectConfig{})
+	passwordHash, _ := hashPassword("open sesame")
+	mustSetLink(t, db, Link{Slug: "secret", URL: "https://example.com/secret", PasswordHash: string(passwordHash)})
+
+	if w := follow(handler, "/secret"); w.Code != http.StatusUnauthorized {
+		t.Errorf("status without password = %d, want 401", w.Code)
+	}
+
+	unlock := func(password string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/secret", strings.NewReader(url.Values{"password": {password}}.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.SetPathValue("id", "secret")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+	if w := unlock("wrong"); w.Code != http.StatusForbidden || len(w.Result().Cookies()) != 0 {
+		t.Errorf("wrong password status = %d with %d cookies, want 403 and none", w.Code, len(w.Result().Cookies()))
+	}
+	w := unlock("open sesame")
+	if w.Code != http.StatusSeeOther || len(w.Result().Cookies()) != 1 {
+		t.Fatalf("right password status = %d with %d cookies, want 303 and an unlock cookie", w.Code, len(w.Result().Cookies()))
+	}
+	if link := mustGetLink(t, db, "secret"); link.Clicks != 0 {
+		t.Errorf("clicks = %d after unlocking, want the click counted once the visitor comes back", link.Clicks)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/secret", nil)
+	r.SetPathValue("id", "secret")
+	r.AddCookie(w.Result().Cookies()[0])
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "https://example.com/secret" {
+		t.Errorf("unlocked status = %d to %q, want a redirect to the destination", w.Code, w.Header().Get("Location"))
+	}
+}
+
+func TestChangingPasswordLocksOutUnlocks(t *testing.T) {
+	clock := newTestClock()
+	db, jwt := newTestDB(clock), newTestJWT(clock)
+	ctx := t.Context()
+	handler := epRedirectWithClock(db, jwt, clock, RedirectConfig{})
+	oldHash, _ := hashPassword("one")
+	mustSetLink(t, db, Link{Slug: "secret", URL: "https://example.com/secret", PasswordHash: string(oldHash)})
+	db.AddAlias(ctx, "secret", "hidden")
+
+	visit := func(path string, cookie *http.Cookie) int {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		r.SetPathValue("id", strings.TrimPrefix(path, "/"))
+		r.AddCookie(cookie)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+	cookie := unlockCookie(t, jwt, mustGetLink(t, db, "secret"))
+	if code := visit("/secret", cookie); code != http.StatusFound {
+		t.Fatalf("unlocked status = %d, want 302", code)
+	}
+	// Unlocking through an alias unlocks the link it belongs to
+	link, _ := db.ResolveLink(ctx, "hidden")
+	w := httptest.NewRecorder()
+	jwt.setUnlockJWT(w, "hidden", link)
+	aliasCookie := w.Result().Cookies()[0]
+	if code := visit("/hidden", aliasCookie); aliasCookie.Path != "/hidden" || code != http.StatusFound {
+		t.Errorf("alias unlocked at %q with status %d, want /hidden and 302", aliasCookie.Path, code)
+	}
+
+	newHash, _ := hashPassword("two")
+	err := db.UpdateLink(ctx, "secret", "secret", "api", func(link *Link) error {
+		link.PasswordHash = string(newHash)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("UpdateLink: %v", err)
+	}
+	if code := visit("/secret", cookie); code != http.StatusUnauthorized {
+		t.Errorf("status after changing the password = %d, want 401", code)
+	}
+	if code := visit("/hidden", aliasCookie); code != http.StatusUnauthorized {
+		t.Errorf("alias status after changing the password = %d, want 401", code)
+	}
+
+	// A new link at the same slug isn't unlocked either, even with the same password
+	cookie = unlockCookie(t, jwt, mustGetLink(t, db, "secret"))
+	err = db.TrashLink(ctx, "secret", "api")
+	if err == nil {
+		err = db.PurgeLink(ctx, "secret")
+	}
+	if err != nil {
+		t.Fatalf("deleting link: %v", err)
+	}
+	samePassword, _ := hashPassword("two")
+	mustSetLink(t, db, Link{Slug: "secret", URL: "https://example.org", PasswordHash: string(samePassword)})
+	if code := visit("/secret", cookie); code != http.StatusUnauthorized {
+		t.Errorf("status for a new link at the slug = %d, want 401", code)
+	}
+}
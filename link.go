@@ -1,54 +1,174 @@
 package main
 
 import (
+	"cmp"
 	"errors"
 	"html/template"
 	"net/http"
+	"net/url"
 	"nyooom/logging"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
 // Helper function to render link cards template
-func renderLinkCards(w http.ResponseWriter, links []Link) error {
-	tmpl, err := template.ParseFiles("static/link-cards.html")
+func renderLinkCards(w http.ResponseWriter, page LinkPage) error {
+	tmpl, err := template.New("link-cards.html").Funcs(linkTemplateFuncs).ParseFiles("static/link-cards.html")
 	if err != nil {
 		return err
 	}
 	w.Header().Set("Content-Type", "text/html")
-	return tmpl.Execute(w, links)
+	return tmpl.Execute(w, page)
+}
+
+// Destinations are checked by cleanURL before they're stored, so they're safe to use in links even when
+// html/template doesn't recognize their scheme
+var linkTemplateFuncs = template.FuncMap{
+	"safeURL": func(url string) template.URL { return template.URL(url) },
+	"join":    strings.Join,
 }
 
 type Link struct {
-	Slug      string
-	URL       string
-	Clicks    int
-	LastClick *time.Time
+	Slug         string         `json:"slug"`
+	Title        string         `json:"title"`
+	Notes        string         `json:"notes"`   // Free-form, for remembering why the link exists
+	Aliases      []string       `json:"aliases"` // Other slugs that lead to the link, sharing its clicks
+	Tags         []string       `json:"tags"`    // Normalized by normalizeTag, with "/" separating folders
+	Kind         LinkKind       `json:"kind"`
+	URL          string         `json:"url"`
+	Clicks       int            `json:"clicks"`
+	LastClick    *time.Time     `json:"last_click"`
+	ExpiresAt    *time.Time     `json:"expires_at"` // Nil for links that never expire
+	MaxClicks    int            `json:"max_clicks"` // How many times the link can be followed, 0 for no limit
+	NotBefore    *time.Time     `json:"not_before"` // Nil for links that redirect as soon as they're created
+	Schedule     []ScheduledURL `json:"schedule"`
+	RedirectCode int            `json:"redirect_code"` // One of redirectCodes
+	Passthrough  bool           `json:"passthrough"`   // Whether extra path and query from visitors are forwarded to the destination
+	QueryMerge   QueryMerge     `json:"query_merge"`   // How forwarded queries combine with the destination's own
+	Disabled     bool           `json:"disabled"`      // Disabled links keep their settings and clicks, but don't redirect
+	FallbackURL  string         `json:"fallback_url"`  // Where visitors go while the link is disabled, empty for DISABLED_LINK_URL
+	CreatedBy    string         `json:"created_by"`    // From requestActor
+	CreatedAt    *time.Time     `json:"created_at"`    // Nil for links made before creation times were kept
+	UpdatedAt    *time.Time     `json:"updated_at"`
+
+	PasswordHash string `json:"-"` // Bcrypt hash visitors must match before redirecting, empty for public links
 }
 
-func newLink(slug string, url string) (Link, error) {
-	logging.Println("Slug: ", slug)
-	logging.Println("URL:  ", url)
-	url, _ = strings.CutPrefix(url, "https://")
-	url, _ = strings.CutPrefix(url, "http://")
+// Redirect status codes a link can use. 301 and 308 are cached by browsers, so repeat visits skip Nyooom entirely
+var redirectCodes = []int{http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect}
+
+// Temporary and uncached, so every click is counted and edits take effect right away
+const defaultRedirectCode = http.StatusFound
+
+const maxNotesLength = 2000
+
+// LinkKind decides how a link's destination is used
+type LinkKind string
+
+const (
+	LinkKindStandard LinkKind = "standard"
+	LinkKindTemplate LinkKind = "template" // Destination has placeholders filled in from the visitor's path and query
+)
+
+// parseLinkKind reads a link kind, where an empty string means a standard link
+func parseLinkKind(raw string) (LinkKind, error) {
+	switch kind := LinkKind(raw); kind {
+	case "":
+		return LinkKindStandard, nil
+	case LinkKindStandard, LinkKindTemplate:
+		return kind, nil
+	}
+	return "", errors.New(raw + " is not a kind of link")
+}
+
+// cleanDestination is cleanURL for the kind of link the URL belongs to
+func cleanDestination(kind LinkKind, url string) (string, error) {
+	if kind == LinkKindTemplate {
+		return cleanTemplateURL(url)
+	}
+	return cleanURL(url)
+}
+
+// QueryMerge decides what happens when a visitor's query and the destination share parameters
+type QueryMerge string
+
+const (
+	QueryMergeAppend      QueryMerge = "append"      // Keep both, destination's values first
+	QueryMergeVisitor     QueryMerge = "visitor"     // Visitor's values replace the destination's
+	QueryMergeDestination QueryMerge = "destination" // Destination's values win, visitor's only fill in new parameters
+)
+
+// parseQueryMerge reads a query merge rule, where an empty string means appending
+func parseQueryMerge(raw string) (QueryMerge, error) {
+	switch merge := QueryMerge(raw); merge {
+	case "":
+		return QueryMergeAppend, nil
+	case QueryMergeAppend, QueryMergeVisitor, QueryMergeDestination:
+		return merge, nil
+	}
+	return "", errors.New(raw + " is not a query merge rule")
+}
+
+// parseRedirectCode reads a redirect status code, where an empty string means the default
+func parseRedirectCode(raw string) (int, error) {
+	if raw == "" {
+		return defaultRedirectCode, nil
+	}
+	code, err := strconv.Atoi(raw)
+	if err != nil {
+		return 0, err
+	}
+	if !slices.Contains(redirectCodes, code) {
+		return 0, errors.New(raw + " is not a supported redirect code")
+	}
+	return code, nil
+}
+
+// ScheduledURL swaps the destination of a link once its time comes
+type ScheduledURL struct {
+	At  time.Time `json:"at"`
+	URL string    `json:"url"`
+}
 
-	// Check if slug and URL are set
+// validateSlug checks a slug or alias can be used in a short link
+func validateSlug(slug string) error {
 	if len(slug) < 3 || // Slug must be at least 3 characters
 		strings.Contains(slug, " ") || // Slug cannot contain spaces
 		strings.Contains(slug, ":") || // Slug cannot contain colons
 		strings.Contains(slug, "/") || // Slug cannot contain forward slashes
-		strings.Contains(slug, "\\") || // Slug cannot contain backslashes
-		strings.Contains(url, " ") || // URL cannot contain spaces
-		!strings.Contains(url, ".") || // URL must contain a dot
-		len(url) < 5 { // URL must be at least 5 characters
-		return Link{}, errors.New("Invalid slug or URL: " + slug + " | " + url)
+		strings.Contains(slug, "\\") { // Slug cannot contain backslashes
+		return errors.New("Invalid slug: " + slug)
+	}
+	if isReservedSlug(slug) {
+		return errors.New("Invalid slug: " + slug + " is reserved")
+	}
+	return nil
+}
+
+func newLink(slug string, url string, kind LinkKind) (Link, error) {
+	logging.Println("Slug: ", slug)
+	logging.Println("URL:  ", url)
+
+	err := validateSlug(slug)
+	if err != nil {
+		return Link{}, err
+	}
+	url, err = cleanDestination(kind, url)
+	if err != nil {
+		return Link{}, err
 	}
 
 	return Link{
-		Slug:   slug,
-		URL:    url,
-		Clicks: 0,
+		Slug:         slug,
+		Kind:         kind,
+		URL:          url,
+		Clicks:       0,
+		RedirectCode: defaultRedirectCode,
+		QueryMerge:   QueryMergeAppend,
 	}, nil
 }
 
@@ -56,7 +176,451 @@ func (link Link) String() string {
 	return link.Slug + " -> " + link.URL + " has " + strconv.Itoa(link.Clicks) + " clicks"
 }
 
-func epCreateLink(db AdvancedDB, jwt JWTService) http.HandlerFunc {
+// IsExpired reports whether the link has stopped redirecting by now
+func (link Link) IsExpired(now time.Time) bool {
+	return link.ExpiresAt != nil && !now.Before(*link.ExpiresAt)
+}
+
+// IsActive reports whether the link has started redirecting by now
+func (link Link) IsActive(now time.Time) bool {
+	return link.NotBefore == nil || !now.Before(*link.NotBefore)
+}
+
+// DestinationAt is where the link points at a given time, after any scheduled changes that have happened
+func (link Link) DestinationAt(now time.Time) string {
+	destination := link.URL
+	for _, change := range link.Schedule { // Sorted oldest first
+		if now.Before(change.At) {
+			break
+		}
+		destination = change.URL
+	}
+	return destination
+}
+
+// UpcomingChanges are the scheduled changes that haven't happened by now
+func (link Link) UpcomingChanges(now time.Time) []ScheduledURL {
+	for i, change := range link.Schedule {
+		if now.Before(change.At) {
+			return link.Schedule[i:]
+		}
+	}
+	return nil
+}
+
+// IsTemplate reports whether the link's destination has placeholders to fill in
+func (link Link) IsTemplate() bool {
+	return link.Kind == LinkKindTemplate
+}
+
+// Resolve is where a visitor goes at a given time, given the extra path and query they followed the link with
+func (link Link) Resolve(now time.Time, extraPath string, query url.Values) (string, error) {
+	destination := link.DestinationAt(now)
+	if link.IsTemplate() {
+		var err error
+		destination, extraPath, query, err = expandTemplate(destination, extraPath, query)
+		if err != nil {
+			return "", err
+		}
+	}
+	if extraPath != "" && !link.Passthrough {
+		return "", errors.New("Could not resolve link " + link.Slug + ": it doesn't forward the path " + extraPath)
+	}
+	return link.ForwardTo(destination, extraPath, query)
+}
+
+// IsProtected reports whether visitors need a password to follow the link
+func (link Link) IsProtected() bool {
+	return link.PasswordHash != ""
+}
+
+// ForwardTo adds the extra path and query a visitor used to a destination of the link.
+// Links without passthrough always go to the destination as is.
+func (link Link) ForwardTo(destination string, extraPath string, query url.Values) (string, error) {
+	if !link.Passthrough {
+		return destination, nil
+	}
+	forwarded, err := url.Parse(destination)
+	if err != nil {
+		return "", errors.New("Could not parse destination " + destination + ": " + err.Error())
+	}
+
+	if extraPath != "" {
+		if forwarded.Opaque != "" { // Like mailto:, there's no path to add to
+			return "", errors.New("Could not forward path " + extraPath + ": destination " + destination + " has no path")
+		}
+		if slices.Contains(strings.Split(extraPath, "/"), "..") { // Stay under the destination's path
+			return "", errors.New("Could not forward path " + extraPath + ": path leaves the destination")
+		}
+		forwarded = forwarded.JoinPath(extraPath)
+	}
+
+	if len(query) > 0 {
+		merged := forwarded.Query()
+		for key, values := range query {
+			switch link.QueryMerge {
+			case QueryMergeVisitor:
+				merged[key] = values
+			case QueryMergeDestination:
+				if _, exists := merged[key]; !exists {
+					merged[key] = values
+				}
+			default:
+				merged[key] = append(merged[key], values...)
+			}
+		}
+		forwarded.RawQuery = merged.Encode()
+	}
+	return forwarded.String(), nil
+}
+
+// WasEdited reports whether the link has changed since it was created
+func (link Link) WasEdited() bool {
+	return link.CreatedAt != nil && link.UpdatedAt != nil && link.UpdatedAt.After(*link.CreatedAt)
+}
+
+// IsPermanent reports whether browsers will cache the link's redirect
+func (link Link) IsPermanent() bool {
+	return link.RedirectCode == http.StatusMovedPermanently || link.RedirectCode == http.StatusPermanentRedirect
+}
+
+// IsUsedUp reports whether the link has hit its click limit
+func (link Link) IsUsedUp() bool {
+	return link.MaxClicks > 0 && link.Clicks >= link.MaxClicks
+}
+
+// RemainingClicks is how many more times a link with a click limit can be followed
+func (link Link) RemainingClicks() int {
+	return max(link.MaxClicks-link.Clicks, 0)
+}
+
+// readLinkOptions fills in the optional settings of a link from a create or update form, or a row being imported.
+// Settings the form doesn't mention are left as they are, and ones it sends empty go back to their defaults.
+// Scheduled changes from before now are dropped.
+func readLinkOptions(form url.Values, link *Link, now time.Time) error {
+	if form.Has("title") {
+		title := strings.TrimSpace(form.Get("title"))
+		if strings.ContainsFunc(title, unicode.IsControl) || utf8.RuneCountInString(title) > maxTitleLength {
+			return errors.New("Invalid title: titles are one line of at most " + strconv.Itoa(maxTitleLength) + " characters")
+		}
+		link.Title = title
+	}
+	if form.Has("notes") {
+		notes := strings.TrimSpace(form.Get("notes"))
+		if utf8.RuneCountInString(notes) > maxNotesLength {
+			return errors.New("Invalid notes: notes can be at most " + strconv.Itoa(maxNotesLength) + " characters")
+		}
+		link.Notes = notes
+	}
+
+	if form.Has("expires_at") {
+		expiresAt, err := parseOptionalTime(form.Get("expires_at"))
+		if err != nil {
+			return errors.New("Invalid expiry time: " + err.Error())
+		}
+		link.ExpiresAt = expiresAt
+	}
+
+	if form.Has("max_clicks") {
+		maxClicks, err := parseOptionalInt(form.Get("max_clicks"))
+		if err != nil || maxClicks < 0 {
+			return errors.New("Invalid click limit: " + form.Get("max_clicks"))
+		}
+		link.MaxClicks = maxClicks
+	}
+
+	if form.Has("passthrough") { // The dashboard sends an empty value along with the checkbox, so unchecking it counts
+		link.Passthrough = slices.Contains(form["passthrough"], "on")
+	}
+	if form.Has("query_merge") {
+		queryMerge, err := parseQueryMerge(form.Get("query_merge"))
+		if err != nil {
+			return errors.New("Invalid query merge rule: " + err.Error())
+		}
+		link.QueryMerge = queryMerge
+	}
+
+	if form.Has("redirect_code") {
+		redirectCode, err := parseRedirectCode(form.Get("redirect_code"))
+		if err != nil {
+			return errors.New("Invalid redirect code: " + err.Error())
+		}
+		link.RedirectCode = redirectCode
+	}
+
+	if form.Has("not_before") {
+		notBefore, err := parseOptionalTime(form.Get("not_before"))
+		if err != nil {
+			return errors.New("Invalid start time: " + err.Error())
+		}
+		link.NotBefore = notBefore
+	}
+
+	if form.Has("fallback_url") {
+		link.FallbackURL = ""
+		if rawFallbackURL := form.Get("fallback_url"); rawFallbackURL != "" {
+			fallbackURL, err := cleanURL(rawFallbackURL)
+			if err != nil {
+				return errors.New("Invalid fallback URL: " + err.Error())
+			}
+			link.FallbackURL = fallbackURL
+		}
+	}
+
+	if form.Has("tags") {
+		tags, err := parseTags(form["tags"])
+		if err != nil {
+			return err
+		}
+		link.Tags = tags
+	}
+
+	if form.Has("schedule_at") || form.Has("schedule_url") {
+		// Scheduled changes come in as matching lists of times and URLs
+		scheduleTimes := form["schedule_at"]
+		scheduleURLs := form["schedule_url"]
+		if len(scheduleTimes) != len(scheduleURLs) {
+			return errors.New("Every scheduled change needs both a time and a URL")
+		}
+		link.Schedule = nil
+		for i := range scheduleTimes {
+			if scheduleTimes[i] == "" && scheduleURLs[i] == "" { // Blank row for adding a change
+				continue
+			}
+			at, err := time.Parse(time.RFC3339, scheduleTimes[i])
+			if err != nil {
+				return errors.New("Invalid scheduled change time: " + scheduleTimes[i])
+			}
+			url, err := cleanDestination(link.Kind, scheduleURLs[i])
+			if err != nil {
+				return errors.New("Invalid scheduled change: " + err.Error())
+			}
+			if !now.Before(at) { // Changes in the past should be made to the URL itself
+				continue
+			}
+			link.Schedule = append(link.Schedule, ScheduledURL{At: at, URL: url})
+		}
+		slices.SortFunc(link.Schedule, func(a ScheduledURL, b ScheduledURL) int {
+			return a.At.Compare(b.At)
+		})
+	}
+	return nil
+}
+
+// readLinkChanges applies an update form to a stored link, checking its destination again if the form changes it or the link's kind.
+// The slug is left alone, since renaming is up to UpdateLink.
+func readLinkChanges(form url.Values, link *Link, now time.Time) error {
+	if form.Has("kind") || form.Has("url") {
+		kind := link.Kind
+		if form.Has("kind") {
+			var err error
+			kind, err = parseLinkKind(form.Get("kind"))
+			if err != nil {
+				return err
+			}
+		}
+		url := link.URL
+		if form.Has("url") {
+			url = form.Get("url")
+		}
+		url, err := cleanDestination(kind, url)
+		if err != nil {
+			return err
+		}
+		if kind != link.Kind { // Scheduled destinations have to suit the new kind too
+			schedule := make([]ScheduledURL, 0, len(link.Schedule))
+			for _, change := range link.Schedule {
+				change.URL, err = cleanDestination(kind, change.URL)
+				if err != nil {
+					return errors.New("Invalid scheduled change: " + err.Error())
+				}
+				schedule = append(schedule, change)
+			}
+			link.Schedule = schedule
+		}
+		link.Kind, link.URL = kind, url
+	}
+	return readLinkOptions(form, link, now)
+}
+
+// readLinkPassword hashes the password a form gives a link, which is empty when the form keeps the current one
+func readLinkPassword(form url.Values) (string, error) {
+	password := form.Get("password")
+	if password == "" {
+		return "", nil
+	}
+	err := checkLinkPassword(form)
+	if err != nil {
+		return "", err
+	}
+	passwordHash, err := hashPassword(password)
+	if err != nil {
+		return "", errors.New("Invalid link password: " + err.Error())
+	}
+	return string(passwordHash), nil
+}
+
+// bcrypt only hashes this many bytes, and refuses longer passwords rather than ignoring the rest
+const maxPasswordLength = 72
+
+// checkLinkPassword validates the password a form gives a link without hashing it, for when it won't be stored
+func checkLinkPassword(form url.Values) error {
+	if len(form.Get("password")) > maxPasswordLength {
+		return errors.New("Invalid link password: passwords can be at most " + strconv.Itoa(maxPasswordLength) + " bytes")
+	}
+	return nil
+}
+
+type LinkSort string
+
+const (
+	LinkSortCreated   LinkSort = "created"
+	LinkSortClicks    LinkSort = "clicks"
+	LinkSortLastClick LinkSort = "last_click"
+	LinkSortSlug      LinkSort = "slug"
+)
+
+const (
+	defaultLinkPageSize = 48
+	maxLinkPageSize     = 500
+)
+
+// LinkQuery picks which page of links to list, and in what order
+type LinkQuery struct {
+	Sort       LinkSort `json:"sort"`
+	Descending bool     `json:"descending"`
+	Offset     int      `json:"offset"`
+	Limit      int      `json:"limit"`
+	Tags       []string `json:"tags"`   // Only list links with all of these tags, or tags in these folders
+	Search     string   `json:"search"` // Only list links with all of these words in their text
+}
+
+// HasTag reports whether the query is filtering by a tag
+func (query LinkQuery) HasTag(tag string) bool {
+	return slices.Contains(query.Tags, tag)
+}
+
+// LinkPage is one page of links along with what's needed to fetch its neighbors
+type LinkPage struct {
+	Links []Link     `json:"links"`
+	Query LinkQuery  `json:"query"`
+	Total int        `json:"total"` // How many links match the query, across every page
+	Tags  []TagCount `json:"tags"`  // Every tag in use, for the sidebar
+	Now   time.Time  `json:"-"`     // When the page was fetched, for showing link status
+}
+
+func defaultLinkQuery() LinkQuery {
+	return LinkQuery{
+		Sort:       LinkSortCreated,
+		Descending: true,
+		Limit:      defaultLinkPageSize,
+	}
+}
+
+// linkQueryFromRequest reads the sort, order, offset, limit, tag, and q parameters.
+// Anything missing or invalid falls back to the newest links first.
+func linkQueryFromRequest(r *http.Request) LinkQuery {
+	query := defaultLinkQuery()
+
+	switch sort := LinkSort(r.FormValue("sort")); sort {
+	case LinkSortCreated, LinkSortClicks, LinkSortLastClick:
+		query.Sort = sort
+	case LinkSortSlug:
+		query.Sort = sort
+		query.Descending = false // Alphabetical reads best A to Z
+	}
+	switch r.FormValue("order") {
+	case "asc":
+		query.Descending = false
+	case "desc":
+		query.Descending = true
+	}
+	if offset, err := strconv.Atoi(r.FormValue("offset")); err == nil && offset > 0 {
+		query.Offset = offset
+	}
+	if limit, err := strconv.Atoi(r.FormValue("limit")); err == nil && limit > 0 {
+		query.Limit = min(limit, maxLinkPageSize)
+	}
+	query.Search = strings.TrimSpace(r.FormValue("q"))
+	for _, rawTag := range r.Form["tag"] {
+		if tag, err := normalizeTag(rawTag); err == nil && !query.HasTag(tag) {
+			query.Tags = append(query.Tags, tag)
+		}
+	}
+	return query
+}
+
+// bounds gives the slice indexes of this query's page within total links
+func (query LinkQuery) bounds(total int) (int, int) {
+	start := min(query.Offset, total)
+	end := min(start+query.Limit, total)
+	return start, end
+}
+
+// sortLinks orders links for a query. Links created at the same time are ordered by slug,
+// and the sort is stable, so other ties keep the order of the links list.
+func sortLinks(links []Link, query LinkQuery) {
+	slices.SortStableFunc(links, func(a Link, b Link) int {
+		var order int
+		switch query.Sort {
+		case LinkSortCreated:
+			order = compareOptionalTime(a.CreatedAt, b.CreatedAt)
+			if order == 0 {
+				order = strings.Compare(a.Slug, b.Slug)
+			}
+		case LinkSortClicks:
+			order = cmp.Compare(a.Clicks, b.Clicks)
+		case LinkSortLastClick:
+			order = compareOptionalTime(a.LastClick, b.LastClick)
+		case LinkSortSlug:
+			order = strings.Compare(strings.ToLower(a.Slug), strings.ToLower(b.Slug))
+		}
+		if query.Descending {
+			return -order
+		}
+		return order
+	})
+}
+
+// compareOptionalTime treats missing times, like links that were never clicked, as the oldest
+func compareOptionalTime(a *time.Time, b *time.Time) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	}
+	return a.Compare(*b)
+}
+
+func (page LinkPage) HasPrevious() bool {
+	return page.Query.Offset > 0
+}
+
+func (page LinkPage) HasNext() bool {
+	return page.Query.Offset+page.Query.Limit < page.Total
+}
+
+func (page LinkPage) PreviousOffset() int {
+	return max(page.Query.Offset-page.Query.Limit, 0)
+}
+
+func (page LinkPage) NextOffset() int {
+	return page.Query.Offset + page.Query.Limit
+}
+
+// FirstNumber and LastNumber are the 1-based positions of the links shown, for display
+func (page LinkPage) FirstNumber() int {
+	return min(page.Query.Offset+1, page.Total)
+}
+
+func (page LinkPage) LastNumber() int {
+	return min(page.Query.Offset+len(page.Links), page.Total)
+}
+
+func epCreateLink(db AdvancedDB, jwt JWTService, clock Clock, slugConfig SlugConfig, titles TitleFetcher) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// Verify user is authenticated
 		err := jwt.ReadAndValidateJWT(r)
@@ -65,33 +629,359 @@ func epCreateLink(db AdvancedDB, jwt JWTService) http.HandlerFunc {
 			return
 		}
 
-		link, err := newLink(r.FormValue("slug"), r.FormValue("url"))
+		if isJSONRequest(r) {
+			err = readJSONForm(r)
+		} else {
+			err = r.ParseForm()
+		}
+		if err != nil {
+			httpError(w, "Failed to read link", http.StatusBadRequest, err)
+			return
+		}
+		kind, err := parseLinkKind(r.FormValue("kind"))
+		if err != nil {
+			httpError(w, "Failed to create link \""+r.FormValue("slug")+".\"", http.StatusBadRequest, err)
+			return
+		}
+		slug := r.FormValue("slug")
+		randomSlug := slug == ""
+		linkCount := 0
+		if randomSlug { // Let Nyooom pick
+			slugs, err := db.GetLinkSlugs(r.Context())
+			if err != nil {
+				httpError(w, "Failed to get links", http.StatusInternalServerError, err)
+				return
+			}
+			linkCount = len(slugs)
+			slug = slugConfig.Generate(slugConfig.LengthFor(linkCount, 0))
+		}
+		link, err := newLink(slug, r.FormValue("url"), kind)
+		if err != nil {
+			httpError(w, "Failed to create link \""+slug+".\"", http.StatusBadRequest, err)
+			return
+		}
+		err = readLinkOptions(r.Form, &link, clock.Now())
 		if err != nil {
 			httpError(w, "Failed to create link \""+link.Slug+".\"", http.StatusBadRequest, err)
 			return
 		}
-		err = db.SetLink(r.Context(), link)
+		link.PasswordHash, err = readLinkPassword(r.Form)
 		if err != nil {
+			httpError(w, "Failed to create link \""+link.Slug+".\"", http.StatusBadRequest, err)
+			return
+		}
+		if link.Title == "" {
+			titles.FillTitle(r.Context(), &link)
+		}
+		link.CreatedBy = requestActor(r)
+		err = db.SetLink(r.Context(), link)
+		// Random slugs that are taken are swapped for new ones, which get longer if it keeps happening
+		for collisions := 1; randomSlug && errors.Is(err, ErrLinkExists) && collisions < maxSlugAttempts; collisions++ {
+			link.Slug = slugConfig.Generate(slugConfig.LengthFor(linkCount, collisions))
+			err = db.SetLink(r.Context(), link)
+		}
+		if errors.Is(err, ErrLinkExists) {
+			httpError(w, "Link \""+link.Slug+"\" already exists", http.StatusConflict, err)
+			return
+		} else if err != nil {
 			httpError(w, "Failed to create link \""+link.Slug+".\" in database", http.StatusInternalServerError, err)
 			return
 		}
 		logging.Println("Created link \"" + link.Slug + "\"")
+		if wantsJSON(r) {
+			created, err := db.GetLink(r.Context(), link.Slug) // With the times the database gave it
+			if err != nil {
+				httpError(w, "Failed to get link \""+link.Slug+"\"", http.StatusInternalServerError, err)
+				return
+			}
+			writeJSON(w, http.StatusCreated, created)
+			return
+		}
 
 		// Return the updated links list for HTMX
-		links, err := db.GetLinks(r.Context())
+		page, err := db.GetLinks(r.Context(), linkQueryFromRequest(r))
 		if err != nil {
 			httpError(w, "Failed to get links", http.StatusInternalServerError, err)
 			return
 		}
 
 		// Render links using template
-		err = renderLinkCards(w, links)
+		err = renderLinkCards(w, page)
 		if err != nil {
 			httpError(w, "Failed to render links", http.StatusInternalServerError, err)
 		}
 	}
 }
 
+func epUpdateLink(db AdvancedDB, jwt JWTService, clock Clock, titles TitleFetcher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Verify user is authenticated
+		err := jwt.ReadAndValidateJWT(r)
+		if err != nil {
+			httpError(w, "JWT is invalid for link update", http.StatusForbidden, err)
+			return
+		}
+		if r.Method != http.MethodPost { // Only allow POST requests
+			httpNewError(w, "Method not allowed for link update", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if isJSONRequest(r) {
+			err = readJSONForm(r)
+		} else {
+			err = r.ParseForm()
+		}
+		if err != nil {
+			httpError(w, "Failed to read link", http.StatusBadRequest, err)
+			return
+		}
+		linkSlug := r.FormValue("slug")
+		newSlug := r.FormValue("new_slug")
+		if newSlug == "" { // Not renaming
+			newSlug = linkSlug
+		} else if newSlug != linkSlug {
+			err = validateSlug(newSlug)
+			if err != nil {
+				httpError(w, "Failed to update link \""+linkSlug+"\"", http.StatusBadRequest, err)
+				return
+			}
+		}
+		// Hashing is slow, so it's done before the transaction rather than each time it's tried
+		passwordHash, err := readLinkPassword(r.Form)
+		if err != nil {
+			httpError(w, "Failed to update link \""+linkSlug+"\"", http.StatusBadRequest, err)
+			return
+		}
+		removePassword := r.FormValue("remove_password") == "on"
+		now := clock.Now()
+
+		// So is fetching a title, which needs to know where the link will point
+		fetchedTitle := ""
+		if r.FormValue("fetch_title") == "on" {
+			if !titles.Enabled() {
+				httpNewError(w, "Fetching titles is turned off, set FETCH_LINK_TITLES=true to turn it on", http.StatusBadRequest)
+				return
+			}
+			preview, err := db.GetLink(r.Context(), linkSlug)
+			if errors.Is(err, ErrLinkNotFound) {
+				httpError(w, "Link \""+linkSlug+"\" does not exist", http.StatusNotFound, err)
+				return
+			} else if err != nil {
+				httpError(w, "Failed to get link \""+linkSlug+"\"", http.StatusInternalServerError, err)
+				return
+			}
+			err = readLinkChanges(r.Form, &preview, now)
+			if err != nil {
+				httpError(w, "Failed to update link \""+linkSlug+"\"", http.StatusBadRequest, err)
+				return
+			}
+			preview.Title = ""
+			titles.FillTitle(r.Context(), &preview)
+			fetchedTitle = preview.Title
+		}
+
+		var invalid error // Set when the form is the problem, rather than the database
+		err = db.UpdateLink(r.Context(), linkSlug, newSlug, requestActor(r), func(link *Link) error {
+			invalid = readLinkChanges(r.Form, link, now)
+			if invalid != nil {
+				return invalid
+			}
+			if fetchedTitle != "" {
+				link.Title = fetchedTitle
+			}
+			// Keep the current password unless it's being changed or removed
+			if passwordHash != "" {
+				link.PasswordHash = passwordHash
+			} else if removePassword {
+				link.PasswordHash = ""
+			}
+			return nil
+		})
+		if invalid != nil {
+			httpError(w, "Failed to update link \""+linkSlug+"\"", http.StatusBadRequest, err)
+			return
+		} else if errors.Is(err, ErrLinkNotFound) {
+			httpError(w, "Link \""+linkSlug+"\" does not exist", http.StatusNotFound, err)
+			return
+		} else if errors.Is(err, ErrLinkExists) {
+			httpError(w, "Link \""+newSlug+"\" already exists", http.StatusConflict, err)
+			return
+		} else if err != nil {
+			httpError(w, "Failed to update link \""+linkSlug+"\" in database", http.StatusInternalServerError, err)
+			return
+		}
+		logging.Println("Updated link \"" + linkSlug + "\"")
+		renderLinksAfterChange(w, r, db, newSlug)
+	}
+}
+
+func epAddAlias(db AdvancedDB, jwt JWTService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Verify user is authenticated
+		err := jwt.ReadAndValidateJWT(r)
+		if err != nil {
+			httpError(w, "JWT is invalid for adding an alias", http.StatusForbidden, err)
+			return
+		}
+		if r.Method != http.MethodPost { // Only allow POST requests
+			httpNewError(w, "Method not allowed for adding an alias", http.StatusMethodNotAllowed)
+			return
+		}
+		if isJSONRequest(r) {
+			err = readJSONForm(r)
+			if err != nil {
+				httpError(w, "Failed to read alias", http.StatusBadRequest, err)
+				return
+			}
+		}
+
+		linkSlug := r.FormValue("slug")
+		alias := r.FormValue("alias")
+		err = validateSlug(alias)
+		if err != nil {
+			httpError(w, "Failed to add alias \""+alias+"\" to link \""+linkSlug+"\"", http.StatusBadRequest, err)
+			return
+		}
+		err = db.AddAlias(r.Context(), linkSlug, alias)
+		if errors.Is(err, ErrLinkNotFound) {
+			httpError(w, "Link \""+linkSlug+"\" does not exist", http.StatusNotFound, err)
+			return
+		} else if errors.Is(err, ErrLinkExists) {
+			httpError(w, "Link \""+alias+"\" already exists", http.StatusConflict, err)
+			return
+		} else if err != nil {
+			httpError(w, "Failed to add alias \""+alias+"\" to link \""+linkSlug+"\" in database", http.StatusInternalServerError, err)
+			return
+		}
+		logging.Println("Added alias \"" + alias + "\" to link \"" + linkSlug + "\"")
+		renderLinksAfterChange(w, r, db, linkSlug)
+	}
+}
+
+func epRemoveAlias(db AdvancedDB, jwt JWTService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Verify user is authenticated
+		err := jwt.ReadAndValidateJWT(r)
+		if err != nil {
+			httpError(w, "JWT is invalid for removing an alias", http.StatusForbidden, err)
+			return
+		}
+		if r.Method != http.MethodPost { // Only allow POST requests
+			httpNewError(w, "Method not allowed for removing an alias", http.StatusMethodNotAllowed)
+			return
+		}
+		if isJSONRequest(r) {
+			err = readJSONForm(r)
+			if err != nil {
+				httpError(w, "Failed to read alias", http.StatusBadRequest, err)
+				return
+			}
+		}
+
+		linkSlug := r.FormValue("slug")
+		alias := r.FormValue("alias")
+		err = db.RemoveAlias(r.Context(), linkSlug, alias)
+		if errors.Is(err, ErrLinkNotFound) {
+			httpError(w, "Link \""+linkSlug+"\" has no alias \""+alias+"\"", http.StatusNotFound, err)
+			return
+		} else if err != nil {
+			httpError(w, "Failed to remove alias \""+alias+"\" from link \""+linkSlug+"\" in database", http.StatusInternalServerError, err)
+			return
+		}
+		logging.Println("Removed alias \"" + alias + "\" from link \"" + linkSlug + "\"")
+		renderLinksAfterChange(w, r, db, linkSlug)
+	}
+}
+
+// epSetLinksDisabled disables or enables every link in the request's slug values
+func epSetLinksDisabled(db AdvancedDB, jwt JWTService, disabled bool) http.HandlerFunc {
+	verb, action := "enable", "enabling"
+	if disabled {
+		verb, action = "disable", "disabling"
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Verify user is authenticated
+		err := jwt.ReadAndValidateJWT(r)
+		if err != nil {
+			httpError(w, "JWT is invalid for "+action+" links", http.StatusForbidden, err)
+			return
+		}
+		if r.Method != http.MethodPost { // Only allow POST requests
+			httpNewError(w, "Method not allowed for "+action+" links", http.StatusMethodNotAllowed)
+			return
+		}
+		if isJSONRequest(r) {
+			err = readJSONForm(r)
+		} else {
+			err = r.ParseForm()
+		}
+		if err != nil {
+			httpError(w, "Failed to read links", http.StatusBadRequest, err)
+			return
+		}
+
+		linkSlugs := r.Form["slug"]
+		if len(linkSlugs) == 0 {
+			httpNewError(w, "Pick some links to "+verb, http.StatusBadRequest)
+			return
+		}
+		links, err := db.SetLinksDisabled(r.Context(), linkSlugs, disabled, requestActor(r))
+		if errors.Is(err, ErrLinkNotFound) {
+			httpError(w, "Some of the links don't exist, so none were "+verb+"d", http.StatusNotFound, err)
+			return
+		} else if err != nil {
+			httpError(w, "Failed to "+verb+" links in database", http.StatusInternalServerError, err)
+			return
+		}
+		if disabled {
+			logging.Println("Disabled " + strconv.Itoa(len(links)) + " links")
+		} else {
+			logging.Println("Enabled " + strconv.Itoa(len(links)) + " links")
+		}
+		if wantsJSON(r) {
+			writeJSON(w, http.StatusOK, links)
+			return
+		}
+
+		page, err := db.GetLinks(r.Context(), linkQueryFromRequest(r))
+		if err != nil {
+			httpError(w, "Failed to get links", http.StatusInternalServerError, err)
+			return
+		}
+
+		// Render links using template
+		err = renderLinkCards(w, page)
+		if err != nil {
+			httpError(w, "Failed to render links", http.StatusInternalServerError, err)
+		}
+	}
+}
+
+// renderLinksAfterChange answers a change to a link with the link for API clients, or the updated links list for HTMX
+func renderLinksAfterChange(w http.ResponseWriter, r *http.Request, db AdvancedDB, linkSlug string) {
+	if wantsJSON(r) {
+		link, err := db.GetLink(r.Context(), linkSlug)
+		if err != nil {
+			httpError(w, "Failed to get link \""+linkSlug+"\"", http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, link)
+		return
+	}
+
+	page, err := db.GetLinks(r.Context(), linkQueryFromRequest(r))
+	if err != nil {
+		httpError(w, "Failed to get links", http.StatusInternalServerError, err)
+		return
+	}
+
+	// Render links using template
+	err = renderLinkCards(w, page)
+	if err != nil {
+		httpError(w, "Failed to render links", http.StatusInternalServerError, err)
+	}
+}
+
 func epDeleteLink(db AdvancedDB, jwt JWTService) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// Verify user is authenticated
@@ -102,14 +992,17 @@ func epDeleteLink(db AdvancedDB, jwt JWTService) http.HandlerFunc {
 		}
 
 		linkSlug := r.URL.Query().Get("slug")
-		err = db.DeleteLink(r.Context(), linkSlug)
-		if err != nil {
+		err = db.TrashLink(r.Context(), linkSlug, requestActor(r))
+		if errors.Is(err, ErrLinkNotFound) {
+			httpError(w, "Link \""+linkSlug+"\" does not exist", http.StatusNotFound, err)
+			return
+		} else if err != nil {
 			httpError(w, "Failed to delete link \""+linkSlug+".\" ", http.StatusInternalServerError, err)
 			return
 		}
-		logging.Println("Deleted link \"" + linkSlug + "\"")
+		logging.Println("Moved link \"" + linkSlug + "\" to the trash")
 		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("Link deleted successfully"))
+		w.Write([]byte("Link moved to the trash"))
 	}
 }
 
@@ -122,14 +1015,18 @@ func epGetLinks(db AdvancedDB, jwt JWTService) http.HandlerFunc {
 			return
 		}
 
-		links, err := db.GetLinks(r.Context())
+		page, err := db.GetLinks(r.Context(), linkQueryFromRequest(r))
 		if err != nil {
 			httpError(w, "Failed to get links", http.StatusInternalServerError, err)
 			return
 		}
+		if wantsJSON(r) {
+			writeJSON(w, http.StatusOK, page)
+			return
+		}
 
 		// Render links using template
-		err = renderLinkCards(w, links)
+		err = renderLinkCards(w, page)
 		if err != nil {
 			httpError(w, "Failed to render links", http.StatusInternalServerError, err)
 		}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestCreateLinkFromDashboard(t *testing.T) {
+	clock := newTestClock()
+	db, jwt := newTestDB(clock), newTestJWT(clock)
+	handler := epCreateLink(db, jwt, clock, loadSlugConfig(), NewTitleFetcher(nil))
+
+	w := postForm(t, jwt, handler, "/api/create-link", url.Values{
+		"slug":  {"docs"},
+		"url":   {"https://example.com/docs"},
+		"title": {"Docs"},
+		"tags":  {"work, docs/api"},
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+	}
+	if w.Header().Get("Content-Type") != "text/html" {
+		t.Errorf("Content-Type = %q, want the link cards for HTMX", w.Header().Get("Content-Type"))
+	}
+
+	link := mustGetLink(t, db, "docs")
+	if link.URL != "https://example.com/docs" || link.Title != "Docs" {
+		t.Errorf("link = %+v, want the form's URL and title", link)
+	}
+	if !slices.Equal(link.Tags, []string{"work", "docs/api"}) {
+		t.Errorf("tags = %v, want [work docs/api]", link.Tags)
+	}
+	if link.CreatedBy != "dashboard" || link.CreatedAt == nil || !link.CreatedAt.Equal(clock.Now()) {
+		t.Errorf("created by %q at %v, want the dashboard now", link.CreatedBy, link.CreatedAt)
+	}
+}
+
+func TestCreateLinkFromAPI(t *testing.T) {
+	clock := newTestClock()
+	db, jwt := newTestDB(clock), newTestJWT(clock)
+	handler := epCreateLink(db, jwt, clock, loadSlugConfig(), NewTitleFetcher(nil))
+
+	w := postJSON(t, jwt, handler, "/api/create-link", `{"url": "https://example.com", "max_clicks": 5, "passthrough": true}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("status = %d, want 201: %s", w.Code, w.Body)
+	}
+	var created Link
+	err := json.Unmarshal(w.Body.Bytes(), &created)
+	if err != nil {
+		t.Fatalf("response isn't a link: %v", err)
+	}
+	if len(created.Slug) != defaultSlugLength {
+		t.Errorf("random slug %q, want %d characters", created.Slug, defaultSlugLength)
+	}
+	if created.MaxClicks != 5 || !created.Passthrough || created.CreatedBy != "api" {
+		t.Errorf("created = %+v, want the JSON's settings", created)
+	}
+}
+
+func TestCreateLinkRejects(t *testing.T) {
+	clock := newTestClock()
+	db, jwt := newTestDB(clock), newTestJWT(clock)
+	handler := epCreateLink(db, jwt, clock, loadSlugConfig(), NewTitleFetcher(nil))
+	mustSetLink(t, db, Link{Slug: "taken", URL: "https://example.com"})
+
+	tests := []struct {
+		name string
+		form url.Values
+		want int
+	}{
+		{"taken slug", url.Values{"slug": {"taken"}, "url": {"https://example.org"}}, http.StatusConflict},
+		{"short slug", url.Values{"slug": {"ab"}, "url": {"https://example.org"}}, http.StatusBadRequest},
+		{"script URL", url.Values{"slug": {"script"}, "url": {"javascript:alert(1)"}}, http.StatusBadRequest},
+		{"negative click limit", url.Values{"slug": {"limit"}, "url": {"https://example.org"}, "max_clicks": {"-1"}}, http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := postForm(t, jwt, handler, "/api/create-link", test.form)
+			if w.Code != test.want {
+				t.Errorf("status = %d, want %d: %s", w.Code, test.want, w.Body)
+			}
+		})
+	}
+
+	t.Run("logged out", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodPost, "/api/create-link?slug=anon&url=https://example.org", nil)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("status = %d, want 403", w.Code)
+		}
+		if _, err := db.GetLink(t.Context(), "anon"); err == nil {
+			t.Error("link was created without a session")
+		}
+	})
+}
+
+func TestUpdateLinkKeepsWhatItLeavesOut(t *testing.T) {
+	clock := newTestClock()
+	db, jwt := newTestDB(clock), newTestJWT(clock)
+	handler := epUpdateLink(db, jwt, clock, NewTitleFetcher(nil))
+	expiresAt, changeAt := clock.Now().Add(48*time.Hour), clock.Now().Add(24*time.Hour)
+	passwordHash, _ := hashPassword("secret")
+	mustSetLink(t, db, Link{
+		Slug:         "demo",
+		URL:          "https://example.com",
+		Tags:         []string{"work"},
+		ExpiresAt:    &expiresAt,
+		MaxClicks:    10,
+		Schedule:     []ScheduledURL{{At: changeAt, URL: "https://example.com/later"}},
+		RedirectCode: http.StatusMovedPermanently,
+		Passthrough:  true,
+		QueryMerge:   QueryMergeVisitor,
+		FallbackURL:  "https://example.com/paused",
+		PasswordHash: string(passwordHash),
+	})
+
+	w := postJSON(t, jwt, handler, "/api/update-link", `{"slug": "demo", "new_slug": "renamed", "url": "https://example.org"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+	}
+	link := mustGetLink(t, db, "renamed")
+	if link.URL != "https://example.org" {
+		t.Errorf("url = %q, want the new one", link.URL)
+	}
+	if !slices.Equal(link.Tags, []string{"work"}) || link.MaxClicks != 10 || link.ExpiresAt == nil || !link.ExpiresAt.Equal(expiresAt) {
+		t.Errorf("tags %v, click limit %d, expiry %v changed, want them kept", link.Tags, link.MaxClicks, link.ExpiresAt)
+	}
+	if len(link.Schedule) != 1 || link.RedirectCode != http.StatusMovedPermanently || !link.Passthrough || link.QueryMerge != QueryMergeVisitor {
+		t.Errorf("schedule %v, redirect %d, passthrough %v, merge %q changed, want them kept", link.Schedule, link.RedirectCode, link.Passthrough, link.QueryMerge)
+	}
+	if link.FallbackURL != "https://example.com/paused" || link.PasswordHash != string(passwordHash) {
+		t.Errorf("fallback %q or password changed, want them kept", link.FallbackURL)
+	}
+
+	// Fields that are sent empty are cleared
+	w = postJSON(t, jwt, handler, "/api/update-link", `{"slug": "renamed", "tags": [], "max_clicks": null, "passthrough": false, "expires_at": "", "redirect_code": ""}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("clearing status = %d, want 200: %s", w.Code, w.Body)
+	}
+	link = mustGetLink(t, db, "renamed")
+	if len(link.Tags) != 0 || link.MaxClicks != 0 || link.Passthrough || link.ExpiresAt != nil || link.RedirectCode != defaultRedirectCode {
+		t.Errorf("link = %+v, want the sent fields cleared", link)
+	}
+	if link.URL != "https://example.org" || len(link.Schedule) != 1 {
+		t.Errorf("url %q, schedule %v changed, want them kept", link.URL, link.Schedule)
+	}
+}
+
+func TestUpdateLinkFromDashboard(t *testing.T) {
+	clock := newTestClock()
+	db, jwt := newTestDB(clock), newTestJWT(clock)
+	handler := epUpdateLink(db, jwt, clock, NewTitleFetcher(nil))
+	mustSetLink(t, db, Link{Slug: "demo", URL: "https://example.com", Passthrough: true})
+
+	// Someone protects the link while the edit form is open
+	passwordHash, _ := hashPassword("secret")
+	err := db.basicDB.SetHash(t.Context(), "demo", map[string]string{"password_hash": string(passwordHash)})
+	if err != nil {
+		t.Fatalf("SetHash: %v", err)
+	}
+
+	// Unchecked checkboxes only send the hidden empty value
+	w := postForm(t, jwt, handler, "/api/update-link", url.Values{
+		"slug":         {"demo"},
+		"new_slug":     {"demo"},
+		"url":          {"https://example.com"},
+		"passthrough":  {""},
+		"password":     {""},
+		"schedule_at":  {""},
+		"schedule_url": {""},
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+	}
+	link := mustGetLink(t, db, "demo")
+	if link.Passthrough {
+		t.Error("passthrough is still on after unchecking it")
+	}
+	if link.PasswordHash != string(passwordHash) {
+		t.Error("password set while the form was open was lost")
+	}
+
+	w = postForm(t, jwt, handler, "/api/update-link", url.Values{"slug": {"demo"}, "remove_password": {"on"}})
+	if w.Code != http.StatusOK {
+		t.Fatalf("removing password status = %d, want 200: %s", w.Code, w.Body)
+	}
+	if link := mustGetLink(t, db, "demo"); link.IsProtected() {
+		t.Error("password is still set after removing it")
+	}
+
+	w = postForm(t, jwt, handler, "/api/update-link", url.Values{"slug": {"demo"}, "url": {"javascript:alert(1)"}})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("bad URL status = %d, want 400", w.Code)
+	}
+	w = postForm(t, jwt, handler, "/api/update-link", url.Values{"slug": {"missing"}, "url": {"https://example.com"}})
+	if w.Code != http.StatusNotFound {
+		t.Errorf("missing link status = %d, want 404", w.Code)
+	}
+}
+
+func TestScheduleFollowsTheClock(t *testing.T) {
+	clock := newTestClock()
+	db, jwt := newTestDB(clock), newTestJWT(clock)
+	create := epCreateLink(db, jwt, clock, loadSlugConfig(), NewTitleFetcher(nil))
+	update := epUpdateLink(db, jwt, clock, NewTitleFetcher(nil))
+	past, soon, later := clock.Now().Add(-time.Minute), clock.Now().Add(time.Hour), clock.Now().Add(2*time.Hour)
+
+	w := postForm(t, jwt, create, "/api/create-link", url.Values{
+		"slug":         {"launch"},
+		"url":          {"https://example.com"},
+		"schedule_at":  {later.Format(time.RFC3339), past.Format(time.RFC3339), soon.Format(time.RFC3339)},
+		"schedule_url": {"https://example.com/later", "https://example.com/past", "https://example.com/soon"},
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+	}
+	link := mustGetLink(t, db, "launch")
+	want := []ScheduledURL{{At: soon, URL: "https://example.com/soon"}, {At: later, URL: "https://example.com/later"}}
+	if !slices.Equal(link.Schedule, want) {
+		t.Errorf("schedule = %v, want the future changes in order", link.Schedule)
+	}
+
+	// Once the first change has happened, saving the same schedule drops it
+	clock.Add(90 * time.Minute)
+	w = postForm(t, jwt, update, "/api/update-link", url.Values{
+		"slug":         {"launch"},
+		"schedule_at":  {soon.Format(time.RFC3339), later.Format(time.RFC3339)},
+		"schedule_url": {"https://example.com/soon", "https://example.com/later"},
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("update status = %d, want 200: %s", w.Code, w.Body)
+	}
+	link = mustGetLink(t, db, "launch")
+	if !slices.Equal(link.Schedule, want[1:]) {
+		t.Errorf("schedule = %v, want only the change still to come", link.Schedule)
+	}
+
+	page, err := db.GetLinks(t.Context(), defaultLinkQuery())
+	if err != nil {
+		t.Fatalf("GetLinks: %v", err)
+	}
+	if !page.Now.Equal(clock.Now()) {
+		t.Errorf("page time = %v, want the clock's %v", page.Now, clock.Now())
+	}
+}
+
+func TestUpdateLinkKeepsTitleAndNotes(t *testing.T) {
+	clock := newTestClock()
+	db, jwt := newTestDB(clock), newTestJWT(clock)
+	handler := epUpdateLink(db, jwt, clock, NewTitleFetcher(nil))
+	mustSetLink(t, db, Link{Slug: "docs", URL: "https://example.com", Title: "Hi", Notes: "For the team", Tags: []string{"work"}})
+
+	w := postJSON(t, jwt, handler, "/api/update-link", `{"slug": "docs", "new_slug": "guide"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+	}
+	link := mustGetLink(t, db, "guide")
+	if link.Title != "Hi" || link.Notes != "For the team" || !slices.Equal(link.Tags, []string{"work"}) {
+		t.Errorf("title %q, notes %q, tags %v after renaming, want them kept", link.Title, link.Notes, link.Tags)
+	}
+
+	w = postJSON(t, jwt, handler, "/api/update-link", `{"slug": "guide", "title": ""}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("clearing status = %d, want 200: %s", w.Code, w.Body)
+	}
+	link = mustGetLink(t, db, "guide")
+	if link.Title != "" || link.Notes != "For the team" {
+		t.Errorf("title %q, notes %q, want only the title cleared", link.Title, link.Notes)
+	}
+}
+
+func TestSetLinksDisabledIsAllOrNothing(t *testing.T) {
+	clock := newTestClock()
+	db, jwt := newTestDB(clock), newTestJWT(clock)
+	disable, enable := epSetLinksDisabled(db, jwt, true), epSetLinksDisabled(db, jwt, false)
+	mustSetLink(t, db, Link{Slug: "one", URL: "https://example.com/1"})
+	mustSetLink(t, db, Link{Slug: "two", URL: "https://example.com/2"})
+
+	w := postForm(t, jwt, disable, "/api/disable-links", url.Values{"slug": {"one", "missing"}})
+	if w.Code != http.StatusNotFound {
+		t.Errorf("disabling a missing link: status = %d, want 404", w.Code)
+	}
+	if mustGetLink(t, db, "one").Disabled {
+		t.Error("one was disabled by a request that failed")
+	}
+
+	clock.Add(time.Minute)
+	w = postJSON(t, jwt, disable, "/api/disable-links", `{"slug": ["one", "two", "one"]}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+	}
+	var links []Link
+	err := json.Unmarshal(w.Body.Bytes(), &links)
+	if err != nil || len(links) != 2 || !links[0].Disabled || !links[1].Disabled {
+		t.Errorf("response = %s, want both links disabled", w.Body)
+	}
+	for _, slug := range []string{"one", "two"} {
+		if link := mustGetLink(t, db, slug); !link.Disabled || !link.UpdatedAt.Equal(clock.Now()) {
+			t.Errorf("%s disabled %v, updated %v, want disabled now", slug, link.Disabled, link.UpdatedAt)
+		}
+	}
+
+	// Disabling again changes nothing, so it isn't recorded
+	postForm(t, jwt, disable, "/api/disable-links", url.Values{"slug": {"one"}})
+	postForm(t, jwt, enable, "/api/enable-links", url.Values{"slug": {"one"}})
+	if mustGetLink(t, db, "one").Disabled {
+		t.Error("one is still disabled after enabling it")
+	}
+	history, err := db.GetLinkHistory(t.Context(), "one")
+	if err != nil {
+		t.Fatalf("GetLinkHistory: %v", err)
+	}
+	actions := []HistoryAction{}
+	for _, entry := range history {
+		actions = append(actions, entry.Action)
+	}
+	if !slices.Equal(actions, []HistoryAction{HistoryEnabled, HistoryDisabled, HistoryCreated}) {
+		t.Errorf("history = %v, want one entry for each change", actions)
+	}
+}
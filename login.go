@@ -38,7 +38,11 @@ func epLogin(db AdvancedDB, jwt JWTService) http.HandlerFunc {
 		}
 
 		realHash, err := db.GetUser(r.Context())
-		if err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			logging.Println("No users exist")
+			http.Redirect(w, r, "/create-account", http.StatusTemporaryRedirect)
+			return
+		} else if err != nil {
 			httpError(w, "Could not validate password", http.StatusInternalServerError, err)
 			return
 		}
@@ -83,7 +87,7 @@ func epCreateUser(db AdvancedDB, jwt JWTService) http.HandlerFunc {
 			httpNewError(w, "Password must be at least 8 characters", http.StatusBadRequest)
 			return
 		}
-		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 10)
+		hashedPassword, err := hashPassword(password)
 		if err != nil {
 			httpError(w, "Failed to hash new password", http.StatusInternalServerError, err)
 			return
@@ -105,3 +109,7 @@ func epCreateUser(db AdvancedDB, jwt JWTService) http.HandlerFunc {
 func (s *JWTService) ValidatePassword(passwordAttempt string, realPasswordHash []byte) bool {
 	return bcrypt.CompareHashAndPassword(realPasswordHash, []byte(passwordAttempt)) == nil
 }
+
+func hashPassword(password string) ([]byte, error) {
+	return bcrypt.GenerateFromPassword([]byte(password), 10)
+}
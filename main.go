@@ -17,7 +17,10 @@ func main() {
 
 	// Running
 	logging.Println("Hello, World")
-	setupEndpoints(db, jwt, devMode)
+	trashRetention := loadTrashRetention()
+	setupEndpoints(db, jwt, devMode, trashRetention)
+	reportReservedLinks(db)
+	go sweepTrash(db, trashRetention)
 
 	// Configure server with timeouts
 	server := &http.Server{
@@ -29,23 +32,46 @@ func main() {
 	server.ListenAndServe()
 }
 
-func setupEndpoints(db AdvancedDB, jwt JWTService, devMode bool) {
+func setupEndpoints(db AdvancedDB, jwt JWTService, devMode bool, trashRetention time.Duration) {
+	reserveSlugs()
+	// Registers a route and reserves its path, so links can't shadow it
+	handle := func(pattern string, handler http.Handler) {
+		http.Handle(pattern, handler)
+		reserveRoute(pattern)
+	}
+
 	// Functional endpoints
-	http.HandleFunc("/api/create-link", epCreateLink(db, jwt))
-	http.HandleFunc("/api/delete-link", epDeleteLink(db, jwt))
-	http.HandleFunc("/api/get-links", epGetLinks(db, jwt))
-	http.HandleFunc("/api/login", epLogin(db, jwt))
-	http.HandleFunc("/api/create-user", epCreateUser(db, jwt))
-	http.HandleFunc("/qr/{id}", epQRCode(db, devMode))
-	http.HandleFunc("/{id}", epRedirect(db))
+	titles := loadTitleFetcher()
+	clock := RealClock{}
+	handle("/api/create-link", epCreateLink(db, jwt, clock, loadSlugConfig(), titles))
+	handle("/api/update-link", epUpdateLink(db, jwt, clock, titles))
+	handle("/api/add-alias", epAddAlias(db, jwt))
+	handle("/api/remove-alias", epRemoveAlias(db, jwt))
+	handle("/api/tag-links", epTagLinks(db, jwt))
+	handle("/api/import-links", epImportLinks(db, jwt, clock))
+	handle("/api/enable-links", epSetLinksDisabled(db, jwt, false))
+	handle("/api/disable-links", epSetLinksDisabled(db, jwt, true))
+	handle("/api/rollback-link", epRollbackLink(db, jwt))
+	handle("/api/link-history", epLinkHistory(db, jwt))
+	handle("/api/delete-link", epDeleteLink(db, jwt))
+	handle("/api/get-trash", epGetTrash(db, jwt, trashRetention))
+	handle("/api/restore-link", epRestoreLink(db, jwt))
+	handle("/api/purge-link", epPurgeLink(db, jwt))
+	handle("/api/get-links", epGetLinks(db, jwt))
+	handle("/api/search-links", epSearchLinks(db, jwt))
+	handle("/api/login", epLogin(db, jwt))
+	handle("/api/create-user", epCreateUser(db, jwt))
+	handle("/qr/{id}", epQRCode(db, devMode))
+	handle("/{id}", epRedirect(db, jwt))
+	handle("/{id}/{rest...}", epRedirect(db, jwt)) // Extra path for links with passthrough
 
 	// UI endpoints
 	fileServer := http.FileServer(http.Dir("./static"))
-	http.Handle("/static/", http.StripPrefix("/static/", noCacheInDevMode(fileServer, devMode)))
-	http.HandleFunc("/", epBase(db, jwt))
-	http.HandleFunc("/create-account", epCreateUserPage(db))
-	http.HandleFunc("/login", epLoginPage(db, jwt))
-	http.HandleFunc("/dashboard", epDashboardPage(jwt))
+	handle("/static/", http.StripPrefix("/static/", noCacheInDevMode(fileServer, devMode)))
+	handle("/", epBase(db, jwt))
+	handle("/create-account", epCreateUserPage(db))
+	handle("/login", epLoginPage(db, jwt))
+	handle("/dashboard", epDashboardPage(jwt))
 }
 
 // noCacheInDevMode wraps a handler to prevent caching in dev mode
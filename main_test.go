@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testClock is a Clock and TimeFunc that only moves when a test moves it
+type testClock struct {
+	now time.Time
+}
+
+func newTestClock() *testClock {
+	return &testClock{now: time.Date(2025, time.March, 1, 12, 0, 0, 0, time.UTC)}
+}
+
+func (clock *testClock) Now() time.Time {
+	return clock.now
+}
+
+func (clock *testClock) Add(duration time.Duration) {
+	clock.now = clock.now.Add(duration)
+}
+
+// newTestDB is a DB on a fresh MemoryDB, so tests don't need Valkey
+func newTestDB(clock *testClock) DB {
+	return DB{basicDB: NewMemoryDB(clock.Now), timeFunc: clock.Now}
+}
+
+func newTestJWT(clock *testClock) JWTService {
+	return NewJWTService("test secret", clock.Now)
+}
+
+// loggedIn adds a dashboard session to a request
+func loggedIn(t *testing.T, jwt JWTService, r *http.Request) *http.Request {
+	t.Helper()
+	token, err := jwt.GenerateJWT(time.Hour)
+	if err != nil {
+		t.Fatalf("GenerateJWT: %v", err)
+	}
+	r.AddCookie(&http.Cookie{Name: jwt.cookieName, Value: token})
+	return r
+}
+
+// postForm sends a form to a handler the way the dashboard does
+func postForm(t *testing.T, jwt JWTService, handler http.Handler, target string, form url.Values) *httptest.ResponseRecorder {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodPost, target, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, loggedIn(t, jwt, r))
+	return w
+}
+
+// postJSON sends a JSON body to a handler the way API clients do
+func postJSON(t *testing.T, jwt JWTService, handler http.Handler, target string, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, loggedIn(t, jwt, r))
+	return w
+}
+
+// mustSetLink stores a link for a test to work with
+func mustSetLink(t *testing.T, db DB, link Link) {
+	t.Helper()
+	if link.RedirectCode == 0 {
+		link.RedirectCode = defaultRedirectCode
+	}
+	if link.Kind == "" {
+		link.Kind = LinkKindStandard
+	}
+	err := db.SetLink(t.Context(), link)
+	if err != nil {
+		t.Fatalf("SetLink(%s): %v", link.Slug, err)
+	}
+}
+
+func mustGetLink(t *testing.T, db DB, slug string) Link {
+	t.Helper()
+	link, err := db.GetLink(t.Context(), slug)
+	if err != nil {
+		t.Fatalf("GetLink(%s): %v", slug, err)
+	}
+	return link
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"nyooom/logging"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// migration upgrades the keyspace by exactly one version
+type migration struct {
+	description string
+	migrate     func(ctx context.Context, db DB) error
+}
+
+// migrations[i] upgrades the database from version i to version i+1.
+// Only ever append to this list, since released versions are stored in existing databases.
+var migrations = []migration{
+	{
+		description: "Initialize keyspace",
+		migrate: func(ctx context.Context, db DB) error {
+			return nil
+		},
+	},
+	{
+		description: "Store link destinations with their scheme",
+		migrate:     addURLSchemes,
+	},
+	{
+		description: "Index slugs for case-insensitive lookup",
+		migrate:     indexFoldedSlugs,
+	},
+	{
+		description: "Index links for search",
+		migrate:     indexAllLinks,
+	},
+	{
+		description: "Only index the start of link notes for search",
+		migrate:     unindexLongNotes,
+	},
+}
+
+const (
+	migrationLockKey      = "migration-lock"
+	migrationLockDuration = 10 * time.Minute // Lets another instance take over if this one dies mid-migration
+	migrationLockRetry    = time.Second
+)
+
+// migrate brings the database up to the version this binary understands.
+// Only one instance migrates at a time, others wait for the lock and then find the database already current.
+func (db DB) migrate() {
+	ctx := context.Background()
+	latestVersion := len(migrations)
+	lockToken := generateRandomString(15)
+
+	for {
+		currentVersion, err := db.storedVersion(ctx)
+		if err != nil {
+			panic("Failed to read database version: " + err.Error())
+		}
+		if currentVersion > latestVersion {
+			panic("Database is at version " + strconv.Itoa(currentVersion) + " but this version of Nyooom only understands up to version " + strconv.Itoa(latestVersion) + ". Please upgrade Nyooom.")
+		}
+		if currentVersion == latestVersion {
+			logging.Println("Database is up to date at version " + strconv.Itoa(currentVersion))
+			return
+		}
+
+		acquired, err := db.basicDB.SetIfNotExists(ctx, migrationLockKey, lockToken, migrationLockDuration)
+		if err != nil {
+			panic("Failed to acquire migration lock: " + err.Error())
+		}
+		if !acquired {
+			logging.Println("Waiting for another instance to finish migrating the database")
+			time.Sleep(migrationLockRetry)
+			continue
+		}
+
+		err = db.runMigrations(ctx)
+		db.releaseMigrationLock(ctx, lockToken)
+		if err != nil {
+			panic("Failed to migrate database: " + err.Error())
+		}
+	}
+}
+
+// runMigrations applies every pending migration in order. The caller must hold the migration lock.
+func (db DB) runMigrations(ctx context.Context) error {
+	// Re-read now that the lock is held, another instance may have just finished
+	currentVersion, err := db.storedVersion(ctx)
+	if err != nil {
+		return err
+	}
+	for version := currentVersion; version < len(migrations); version++ {
+		step := migrations[version]
+		logging.Println("Migrating database from version " + strconv.Itoa(version) + " to " + strconv.Itoa(version+1) + ": " + step.description)
+		err = step.migrate(ctx, db)
+		if err != nil {
+			return errors.New("Migration to version " + strconv.Itoa(version+1) + " failed: " + err.Error())
+		}
+		err = db.SetVersion(ctx, strconv.Itoa(version+1))
+		if err != nil {
+			return err
+		}
+	}
+	logging.Println("Database migrated to version " + strconv.Itoa(len(migrations)))
+	return nil
+}
+
+func (db DB) releaseMigrationLock(ctx context.Context, lockToken string) {
+	holder, err := db.basicDB.Get(ctx, migrationLockKey)
+	if err != nil || holder != lockToken { // Expired, and possibly taken by someone else
+		logging.PrintErrStr("Migration lock was lost before it could be released")
+		return
+	}
+	err = db.basicDB.Delete(ctx, migrationLockKey)
+	if err != nil {
+		logging.PrintErrStr("Failed to release migration lock: " + err.Error())
+	}
+}
+
+// storedVersion reads the database version, treating a database without one as version 0
+func (db DB) storedVersion(ctx context.Context) (int, error) {
+	exists, err := db.basicDB.Exists(ctx, "version")
+	if err != nil {
+		return 0, errors.New("Could not check if db version exists: " + err.Error())
+	}
+	if !exists {
+		return 0, nil
+	}
+	rawVersion, err := db.GetVersion(ctx)
+	if err != nil {
+		return 0, err
+	}
+	version, err := strconv.Atoi(rawVersion)
+	if err != nil {
+		return 0, errors.New("Could not parse db version \"" + rawVersion + "\": " + err.Error())
+	}
+	return version, nil
+}
+
+// addURLSchemes rewrites destinations saved without a scheme, which were always redirected to over https
+func addURLSchemes(ctx context.Context, db DB) error {
+	slugs, err := db.basicDB.GetList(ctx, "links")
+	if err != nil {
+		return errors.New("Could not get links: " + err.Error())
+	}
+	withScheme := func(url string) string {
+		if url == "" || hasURLScheme(url) { // The same check cleanURL uses, so "example.com/?r=https://x" still gets one
+			return url
+		}
+		return "https://" + url
+	}
+
+	for _, slug := range slugs {
+		err = db.basicDB.Transaction(ctx, []string{slug}, func(tx Tx) error {
+			rawLink, err := tx.GetHash(ctx, slug)
+			if err != nil {
+				return err
+			}
+			if len(rawLink) == 0 {
+				return nil
+			}
+			changes := map[string]string{}
+			if url := withScheme(rawLink["url"]); url != rawLink["url"] {
+				changes["url"] = url
+			}
+			if rawLink["schedule"] != "" {
+				var schedule []ScheduledURL
+				err = json.Unmarshal([]byte(rawLink["schedule"]), &schedule)
+				if err != nil {
+					return errors.New("Could not parse schedule: " + err.Error())
+				}
+				for i := range schedule {
+					schedule[i].URL = withScheme(schedule[i].URL)
+				}
+				rawSchedule, err := json.Marshal(schedule)
+				if err != nil {
+					return errors.New("Could not encode schedule: " + err.Error())
+				}
+				if string(rawSchedule) != rawLink["schedule"] {
+					changes["schedule"] = string(rawSchedule)
+				}
+			}
+			if len(changes) > 0 {
+				tx.SetHash(slug, changes)
+			}
+			return nil
+		})
+		if err != nil {
+			return errors.New("Could not update link " + slug + ": " + err.Error())
+		}
+	}
+	return nil
+}
+
+// indexFoldedSlugs points the case variants of every slug at its link, the first link winning when slugs only differ by case
+func indexFoldedSlugs(ctx context.Context, db DB) error {
+	slugs, err := db.basicDB.GetList(ctx, "links")
+	if err != nil {
+		return errors.New("Could not get links: " + err.Error())
+	}
+	slices.Reverse(slugs) // Oldest first
+	for _, slug := range slugs {
+		_, err = db.basicDB.SetIfNotExists(ctx, foldKey(slug), slug, 0)
+		if err != nil {
+			return errors.New("Could not index link " + slug + ": " + err.Error())
+		}
+	}
+	return nil
+}
+
+// indexAllLinks adds every link to the search index, for links saved before the index had all their text
+func indexAllLinks(ctx context.Context, db DB) error {
+	slugs, err := db.basicDB.GetList(ctx, "links")
+	if err != nil {
+		return errors.New("Could not get links: " + err.Error())
+	}
+	for _, slug := range slugs {
+		link, err := db.GetLink(ctx, slug)
+		if errors.Is(err, ErrLinkNotFound) {
+			continue
+		} else if err != nil {
+			return errors.New("Could not index link " + slug + ": " + err.Error())
+		}
+		for _, key := range link.searchKeys() {
+			err = db.basicDB.AddToSet(ctx, key, slug)
+			if err != nil {
+				return errors.New("Could not index link " + slug + ": " + err.Error())
+			}
+		}
+	}
+	return nil
+}
+
+// unindexLongNotes takes links out of the index sets that only the end of their notes put them in
+func unindexLongNotes(ctx context.Context, db DB) error {
+	slugs, err := db.basicDB.GetList(ctx, "links")
+	if err != nil {
+		return errors.New("Could not get links: " + err.Error())
+	}
+	for _, slug := range slugs {
+		link, err := db.GetLink(ctx, slug)
+		if errors.Is(err, ErrLinkNotFound) {
+			continue
+		} else if err != nil {
+			return errors.New("Could not reindex link " + slug + ": " + err.Error())
+		}
+		if utf8.RuneCountInString(link.Notes) <= maxSearchedNotesLength {
+			continue
+		}
+		keys := link.searchKeys()
+		for _, key := range indexKeys(append(link.searchFields(), strings.ToLower(link.Notes))) {
+			if slices.Contains(keys, key) {
+				continue
+			}
+			err = db.basicDB.RemoveFromSet(ctx, key, slug)
+			if err != nil {
+				return errors.New("Could not reindex link " + slug + ": " + err.Error())
+			}
+		}
+	}
+	return nil
+}
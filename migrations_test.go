@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// withMigrations swaps in a registry for the length of a test
+func withMigrations(t *testing.T, steps []migration) {
+	t.Helper()
+	original := migrations
+	migrations = steps
+	t.Cleanup(func() { migrations = original })
+}
+
+// recordingMigrations are steps that note which versions they migrated to
+func recordingMigrations(count int, ran *[]int) []migration {
+	steps := make([]migration, count)
+	for i := range steps {
+		steps[i] = migration{
+			description: "Step " + strconv.Itoa(i+1),
+			migrate: func(ctx context.Context, db DB) error {
+				*ran = append(*ran, i+1)
+				return nil
+			},
+		}
+	}
+	return steps
+}
+
+func TestMigrateRunsPendingStepsInOrder(t *testing.T) {
+	var ran []int
+	withMigrations(t, recordingMigrations(4, &ran))
+	db := newTestDB(newTestClock())
+	db.SetVersion(t.Context(), "1")
+
+	db.migrate()
+	if !slices.Equal(ran, []int{2, 3, 4}) {
+		t.Errorf("ran steps %v, want [2 3 4]", ran)
+	}
+	version, _ := db.storedVersion(t.Context())
+	if version != 4 {
+		t.Errorf("version = %d, want 4", version)
+	}
+	if exists, _ := db.basicDB.Exists(t.Context(), migrationLockKey); exists {
+		t.Error("migration lock wasn't released")
+	}
+
+	db.migrate()
+	if len(ran) != 3 {
+		t.Errorf("ran steps %v after migrating twice, want each step once", ran)
+	}
+}
+
+func TestMigrateStopsAtFailedStep(t *testing.T) {
+	var ran []int
+	steps := recordingMigrations(3, &ran)
+	steps[1].migrate = func(ctx context.Context, db DB) error { return errors.New("broken") }
+	withMigrations(t, steps)
+	db := newTestDB(newTestClock())
+
+	err := db.runMigrations(t.Context())
+	if err == nil {
+		t.Fatal("runMigrations succeeded, want the failed step's error")
+	}
+	version, _ := db.storedVersion(t.Context())
+	if version != 1 || !slices.Equal(ran, []int{1}) {
+		t.Errorf("version %d after steps %v, want the database left at the last step that worked", version, ran)
+	}
+}
+
+func TestMigrateRefusesNewerDatabase(t *testing.T) {
+	db := newTestDB(newTestClock())
+	db.SetVersion(t.Context(), strconv.Itoa(len(migrations)+1))
+
+	defer func() {
+		if recover() == nil {
+			t.Error("migrate accepted a database from a newer version of Nyooom")
+		}
+	}()
+	db.migrate()
+}
+
+func TestMigrateWaitsForLock(t *testing.T) {
+	var ran []int
+	withMigrations(t, recordingMigrations(1, &ran))
+	db := newTestDB(newTestClock())
+	db.basicDB.SetIfNotExists(t.Context(), migrationLockKey, "other instance", migrationLockDuration)
+
+	done := make(chan struct{})
+	go func() {
+		db.migrate()
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("migrated while another instance held the lock")
+	case <-time.After(migrationLockRetry / 2):
+	}
+
+	// Another instance's lock is left alone
+	db.releaseMigrationLock(t.Context(), "not the holder")
+	if exists, _ := db.basicDB.Exists(t.Context(), migrationLockKey); !exists {
+		t.Fatal("released a lock held by another instance")
+	}
+
+	db.basicDB.Delete(t.Context(), migrationLockKey)
+	select {
+	case <-done:
+	case <-time.After(5 * migrationLockRetry):
+		t.Fatal("didn't migrate once the lock was released")
+	}
+	if len(ran) != 1 {
+		t.Errorf("ran steps %v, want the pending step once", ran)
+	}
+}
+
+func TestMigrateFreshDatabase(t *testing.T) {
+	db := newTestDB(newTestClock())
+	db.migrate()
+	version, err := db.storedVersion(t.Context())
+	if err != nil || version != len(migrations) {
+		t.Errorf("version = %d, %v, want %d", version, err, len(migrations))
+	}
+}
+
+func TestAddURLSchemes(t *testing.T) {
+	db := newTestDB(newTestClock())
+	ctx := t.Context()
+	legacy := map[string]string{
+		"plain":    "example.com",
+		"redirect": "example.com/?r=https://example.org",
+		"port":     "example.com:8080/admin",
+		"done":     "http://example.com",
+		"mail":     "mailto:team@example.com",
+	}
+	for slug, url := range legacy {
+		db.basicDB.SetHash(ctx, slug, map[string]string{"url": url})
+		db.basicDB.AddToList(ctx, "links", slug)
+	}
+	db.basicDB.SetHash(ctx, "plain", map[string]string{"schedule": `[{"at":"2025-03-02T00:00:00Z","url":"example.com/later?next=http://x"}]`})
+
+	err := addURLSchemes(ctx, db)
+	if err != nil {
+		t.Fatalf("addURLSchemes: %v", err)
+	}
+	want := map[string]string{
+		"plain":    "https://example.com",
+		"redirect": "https://example.com/?r=https://example.org",
+		"port":     "https://example.com:8080/admin",
+		"done":     "http://example.com",
+		"mail":     "mailto:team@example.com",
+	}
+	for slug, url := range want {
+		hash, _ := db.basicDB.GetHash(ctx, slug)
+		if hash["url"] != url {
+			t.Errorf("%s url = %q, want %q", slug, hash["url"], url)
+		}
+	}
+	hash, _ := db.basicDB.GetHash(ctx, "plain")
+	if hash["schedule"] != `[{"at":"2025-03-02T00:00:00Z","url":"https://example.com/later?next=http://x"}]` {
+		t.Errorf("schedule = %s, want its URL given a scheme", hash["schedule"])
+	}
+}
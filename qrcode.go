@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"errors"
 	"io"
 	"net/http"
 	"strings"
@@ -21,11 +22,14 @@ func epQRCode(db AdvancedDB, devMode bool) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		slug := strings.TrimPrefix(r.PathValue("id"), "/")
 
-		// Verify the link exists
-		_, err := db.GetLink(r.Context(), slug)
-		if err != nil {
+		// Verify the link exists, aliases and case variants included since they redirect too
+		_, err := db.ResolveLink(r.Context(), slug)
+		if errors.Is(err, ErrLinkNotFound) {
 			httpError(w, "Couldn't find the URL you were looking for :(", http.StatusNotFound, err)
 			return
+		} else if err != nil {
+			httpError(w, "Failed to look up link", http.StatusInternalServerError, err)
+			return
 		}
 
 		// Generate the full shortened URL
@@ -48,7 +52,7 @@ func epQRCode(db AdvancedDB, devMode bool) http.HandlerFunc {
 
 		// Write QR code to buffer
 		buf := new(bytes.Buffer)
-		w2 := standard.NewWithWriter(nopCloser{buf}, standard.WithQRWidth(21))
+		w2 := standard.NewWithWriter(nopCloser{buf}, standard.WithQRWidth(21), standard.WithBuiltinImageEncoder(standard.PNG_FORMAT))
 		if err = qrc.Save(w2); err != nil {
 			httpError(w, "Failed to save QR code", http.StatusInternalServerError, err)
 			return
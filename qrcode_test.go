@@ -0,0 +1,37 @@
+package main
+
+import (
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestQRCodeForAliases(t *testing.T) {
+	original := caseInsensitiveSlugs
+	caseInsensitiveSlugs = true
+	t.Cleanup(func() { caseInsensitiveSlugs = original })
+
+	db := newTestDB(newTestClock())
+	mustSetLink(t, db, Link{Slug: "docs", URL: "https://example.com/docs"})
+	err := db.AddAlias(t.Context(), "docs", "guide")
+	if err != nil {
+		t.Fatalf("AddAlias: %v", err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/qr/{id}", epQRCode(db, false))
+
+	for slug, want := range map[string]int{"docs": http.StatusOK, "guide": http.StatusOK, "Docs": http.StatusOK, "missing": http.StatusNotFound} {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/qr/"+slug, nil))
+		if w.Code != want {
+			t.Errorf("QR code for %s: status = %d, want %d", slug, w.Code, want)
+			continue
+		}
+		if want == http.StatusOK {
+			if _, err := png.Decode(w.Body); err != nil {
+				t.Errorf("QR code for %s isn't a PNG: %v", slug, err)
+			}
+		}
+	}
+}
@@ -1,8 +1,12 @@
 package main
 
 import (
+	"cmp"
+	"errors"
+	"html/template"
 	"net/http"
 	"nyooom/logging"
+	"os"
 	"strings"
 	"time"
 )
@@ -19,23 +23,113 @@ func (RealClock) Now() time.Time {
 	return time.Now()
 }
 
-func epRedirect(db AdvancedDB) http.HandlerFunc {
-	return epRedirectWithClock(db, RealClock{})
+// RedirectConfig decides where visitors go when a link can't be followed
+type RedirectConfig struct {
+	ExpiredURL  string // Where to send visitors of expired links, empty shows Nyooom's own page
+	DisabledURL string // Where to send visitors of disabled links without a fallback of their own, empty shows Nyooom's own page
 }
 
-func epRedirectWithClock(db AdvancedDB, clock Clock) http.HandlerFunc {
+func loadRedirectConfig() RedirectConfig {
+	return RedirectConfig{
+		ExpiredURL:  os.Getenv("EXPIRED_LINK_URL"),
+		DisabledURL: os.Getenv("DISABLED_LINK_URL"),
+	}
+}
+
+func epRedirect(db AdvancedDB, jwt JWTService) http.HandlerFunc {
+	return epRedirectWithClock(db, jwt, RealClock{}, loadRedirectConfig())
+}
+
+func epRedirectWithClock(db AdvancedDB, jwt JWTService, clock Clock, config RedirectConfig) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		slug := strings.TrimPrefix(r.PathValue("id"), "/")
-		link, err := db.GetLink(r.Context(), slug)
+		link, err := db.ResolveLink(r.Context(), slug)
+		if errors.Is(err, ErrLinkNotFound) {
+			httpErrorPage(w, "Link not found", "Couldn't find the URL you were looking for :(", http.StatusNotFound, err)
+			return
+		} else if err != nil {
+			httpErrorPage(w, "Something went wrong", "Couldn't load the URL you were looking for, please try again later", http.StatusInternalServerError, err)
+			return
+		}
+		if link.Disabled {
+			w.Header().Set("Cache-Control", "no-store") // Visitors should reach the link again once it's enabled
+			if fallbackURL := cmp.Or(link.FallbackURL, config.DisabledURL); fallbackURL != "" {
+				http.Redirect(w, r, fallbackURL, http.StatusFound)
+				return
+			}
+			httpErrorPage(w, "Link paused", "This link has been paused, please check back later", http.StatusServiceUnavailable, errors.New("link "+slug+" is disabled"))
+			return
+		}
 
+		// Checked before the visitor's path, which can't matter while the link goes nowhere
+		now := clock.Now()
+		if !link.IsActive(now) {
+			httpErrorPage(w, "Link not live yet", "This link hasn't started redirecting yet, please check back later", http.StatusNotFound, errors.New("link "+slug+" is not active yet"))
+			return
+		}
+		if link.IsExpired(now) {
+			if config.ExpiredURL != "" {
+				http.Redirect(w, r, config.ExpiredURL, http.StatusFound)
+				return
+			}
+			httpErrorPage(w, "Link expired", "This link has expired and no longer goes anywhere", http.StatusGone, errors.New("link "+slug+" expired"))
+			return
+		}
+		destination, err := link.Resolve(now, r.PathValue("rest"), r.URL.Query())
 		if err != nil {
-			httpError(w, "Couldn't find the URL you were looking for :(", http.StatusInternalServerError, err)
+			httpErrorPage(w, "Link not found", "Couldn't find the URL you were looking for :(", http.StatusNotFound, err)
+			return
+		}
+
+		if link.IsProtected() && !jwt.IsUnlocked(r, link) {
+			if r.Method != http.MethodPost {
+				renderUnlockPage(w, slug, "", http.StatusUnauthorized)
+				return
+			}
+			if !jwt.ValidatePassword(r.FormValue("password"), []byte(link.PasswordHash)) {
+				logging.PrintErrStr("Incorrect password for link " + slug)
+				renderUnlockPage(w, slug, "Incorrect password", http.StatusForbidden)
+				return
+			}
+			err = jwt.setUnlockJWT(w, slug, link)
+			if err != nil {
+				httpErrorPage(w, "Something went wrong", "Couldn't unlock this link, please try again later", http.StatusInternalServerError, err)
+				return
+			}
+			// Come back with the unlock cookie set, so the form isn't re-sent to the destination
+			http.Redirect(w, r, r.URL.RequestURI(), http.StatusSeeOther)
 			return
 		}
-		err = db.LinkAnalytics(r.Context(), slug, 1, clock.Now())
-		if err != nil { // Don't error out, it just sucks
+
+		err = db.LinkAnalytics(r.Context(), link.Slug, 1, now) // Aliases count towards the link they belong to
+		if errors.Is(err, ErrLinkUsedUp) {
+			httpErrorPage(w, "Link used up", "This link has been used as many times as it's allowed to be", http.StatusGone, err)
+			return
+		} else if err != nil && link.MaxClicks > 0 { // Can't let an uncounted click slip past the limit
+			httpErrorPage(w, "Something went wrong", "Couldn't load the URL you were looking for, please try again later", http.StatusServiceUnavailable, err)
+			return
+		} else if err != nil { // Don't error out, it just sucks
 			logging.PrintErrStr("Failed to increment clicks for link " + slug + ": " + err.Error())
 		}
-		http.Redirect(w, r, "https://"+link.URL, http.StatusMovedPermanently)
+		if !link.IsPermanent() { // Make sure browsers come back through Nyooom every time
+			w.Header().Set("Cache-Control", "no-store")
+		}
+		http.Redirect(w, r, destination, link.RedirectCode)
+	}
+}
+
+// renderUnlockPage asks a visitor for the password of a protected link
+func renderUnlockPage(w http.ResponseWriter, slug string, message string, code int) {
+	tmpl, err := template.ParseFiles("static/unlock.html")
+	if err != nil {
+		httpError(w, "Failed to load unlock page", http.StatusInternalServerError, err)
+		return
 	}
+	w.Header().Set("Content-Type", "text/html")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	tmpl.Execute(w, struct {
+		Slug  string
+		Error string
+	}{slug, message})
 }
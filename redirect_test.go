@@ -0,0 +1,209 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// follow visits a short link the way a browser would
+func follow(handler http.Handler, path string) *httptest.ResponseRecorder {
+	mux := http.NewServeMux()
+	mux.Handle("/{id}", handler)
+	mux.Handle("/{id}/{rest...}", handler)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	return w
+}
+
+func TestRedirectCountsClicks(t *testing.T) {
+	clock := newTestClock()
+	db, jwt := newTestDB(clock), newTestJWT(clock)
+	handler := epRedirectWithClock(db, jwt, clock, RedirectConfig{})
+	mustSetLink(t, db, Link{Slug: "docs", URL: "https://example.com/docs"})
+
+	for range 2 {
+		w := follow(handler, "/docs")
+		if w.Code != http.StatusFound {
+			t.Fatalf("status = %d, want 302: %s", w.Code, w.Body)
+		}
+		if location := w.Header().Get("Location"); location != "https://example.com/docs" {
+			t.Errorf("Location = %q, want the destination", location)
+		}
+		if w.Header().Get("Cache-Control") != "no-store" {
+			t.Error("temporary redirect can be cached, so clicks would be missed")
+		}
+	}
+	link := mustGetLink(t, db, "docs")
+	if link.Clicks != 2 || link.LastClick == nil || !link.LastClick.Equal(clock.Now()) {
+		t.Errorf("clicks = %d at %v, want 2 now", link.Clicks, link.LastClick)
+	}
+
+	if w := follow(handler, "/missing"); w.Code != http.StatusNotFound {
+		t.Errorf("missing link status = %d, want 404", w.Code)
+	}
+}
+
+func TestRedirectFollowsTheClock(t *testing.T) {
+	clock := newTestClock()
+	db, jwt := newTestDB(clock), newTestJWT(clock)
+	handler := epRedirectWithClock(db, jwt, clock, RedirectConfig{})
+	start, changeAt, end := clock.Now().Add(time.Hour), clock.Now().Add(2*time.Hour), clock.Now().Add(3*time.Hour)
+	mustSetLink(t, db, Link{
+		Slug:      "launch",
+		URL:       "https://example.com/soon",
+		NotBefore: &start,
+		ExpiresAt: &end,
+		Schedule:  []ScheduledURL{{At: changeAt, URL: "https://example.com/live"}},
+	})
+
+	if w := follow(handler, "/launch"); w.Code != http.StatusNotFound {
+		t.Errorf("before start status = %d, want 404", w.Code)
+	}
+	clock.Add(time.Hour)
+	if w := follow(handler, "/launch"); w.Header().Get("Location") != "https://example.com/soon" {
+		t.Errorf("after start Location = %q, want the first destination", w.Header().Get("Location"))
+	}
+	clock.Add(time.Hour)
+	if w := follow(handler, "/launch"); w.Header().Get("Location") != "https://example.com/live" {
+		t.Errorf("after scheduled change Location = %q, want the new destination", w.Header().Get("Location"))
+	}
+	clock.Add(time.Hour)
+	if w := follow(handler, "/launch"); w.Code != http.StatusGone {
+		t.Errorf("after expiry status = %d, want 410", w.Code)
+	}
+}
+
+func TestRedirectPassthrough(t *testing.T) {
+	clock := newTestClock()
+	db, jwt := newTestDB(clock), newTestJWT(clock)
+	handler := epRedirectWithClock(db, jwt, clock, RedirectConfig{})
+	mustSetLink(t, db, Link{Slug: "gh", URL: "https://github.com/org?tab=repos", Passthrough: true, QueryMerge: QueryMergeAppend})
+	mustSetLink(t, db, Link{Slug: "plain", URL: "https://example.com"})
+
+	w := follow(handler, "/gh/repo/issues?q=bug")
+	if location := w.Header().Get("Location"); location != "https://github.com/org/repo/issues?q=bug&tab=repos" {
+		t.Errorf("Location = %q, want the path and query forwarded", location)
+	}
+	if w := follow(handler, "/plain/extra"); w.Code != http.StatusNotFound {
+		t.Errorf("extra path without passthrough status = %d, want 404", w.Code)
+	}
+}
+
+func TestRedirectChecksTimesBeforePath(t *testing.T) {
+	clock := newTestClock()
+	db, jwt := newTestDB(clock), newTestJWT(clock)
+	handler := epRedirectWithClock(db, jwt, clock, RedirectConfig{})
+	start, end := clock.Now().Add(time.Hour), clock.Now().Add(-time.Hour)
+	mustSetLink(t, db, Link{Slug: "soon", URL: "https://example.com", NotBefore: &start})
+	mustSetLink(t, db, Link{Slug: "over", URL: "https://example.com", ExpiresAt: &end, Passthrough: true})
+
+	// A path the link wouldn't forward still gets told the link isn't live
+	if w := follow(handler, "/soon/extra"); w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), "not live yet") {
+		t.Errorf("not yet active status = %d, want 404 saying it isn't live yet: %s", w.Code, w.Body)
+	}
+	if w := follow(handler, "/over/extra/path"); w.Code != http.StatusGone {
+		t.Errorf("expired passthrough status = %d, want 410", w.Code)
+	}
+}
+
+func TestRedirectCodes(t *testing.T) {
+	clock := newTestClock()
+	db, jwt := newTestDB(clock), newTestJWT(clock)
+	handler := epRedirectWithClock(db, jwt, clock, RedirectConfig{})
+
+	for _, code := range redirectCodes {
+		slug := "code-" + strconv.Itoa(code)
+		mustSetLink(t, db, Link{Slug: slug, URL: "https://example.com", RedirectCode: code})
+		w := follow(handler, "/"+slug)
+		if w.Code != code {
+			t.Errorf("status = %d, want %d", w.Code, code)
+		}
+		// Permanent redirects are cached by browsers anyway, so they're allowed to be
+		if cached := w.Header().Get("Cache-Control") != "no-store"; cached != (code == http.StatusMovedPermanently || code == http.StatusPermanentRedirect) {
+			t.Errorf("%d can be cached = %v", code, cached)
+		}
+	}
+
+	// Links saved before redirect codes could be chosen stay temporary
+	db.basicDB.SetHash(t.Context(), "legacy", map[string]string{"url": "https://example.com", "clicks": "0"})
+	if w := follow(handler, "/legacy"); w.Code != defaultRedirectCode {
+		t.Errorf("legacy link status = %d, want %d", w.Code, defaultRedirectCode)
+	}
+}
+
+func TestParseRedirectCode(t *testing.T) {
+	for raw, want := range map[string]int{"": defaultRedirectCode, "301": 301, "302": 302, "307": 307, "308": 308} {
+		if code, err := parseRedirectCode(raw); err != nil || code != want {
+			t.Errorf("parseRedirectCode(%q) = %d, %v, want %d", raw, code, err, want)
+		}
+	}
+	for _, raw := range []string{"200", "303", "404", "permanent"} {
+		if _, err := parseRedirectCode(raw); err == nil {
+			t.Errorf("parseRedirectCode(%q) succeeded, want an error", raw)
+		}
+	}
+}
+
+func TestRedirectTemplate(t *testing.T) {
+	clock := newTestClock()
+	db, jwt := newTestDB(clock), newTestJWT(clock)
+	handler := epRedirectWithClock(db, jwt, clock, RedirectConfig{})
+	end := clock.Now().Add(time.Hour)
+	mustSetLink(t, db, Link{Slug: "gh", URL: "https://github.com/{1}/{2}", Kind: LinkKindTemplate, ExpiresAt: &end})
+	mustSetLink(t, db, Link{Slug: "search", URL: "https://example.com/?q={q}", Kind: LinkKindTemplate, Passthrough: true})
+
+	if location := follow(handler, "/gh/golang/go").Header().Get("Location"); location != "https://github.com/golang/go" {
+		t.Errorf("Location = %q, want the path filled in", location)
+	}
+	if w := follow(handler, "/gh/golang/go/issues"); w.Code != http.StatusNotFound {
+		t.Errorf("extra segment without passthrough status = %d, want 404", w.Code)
+	}
+	if w := follow(handler, "/gh/golang"); w.Code != http.StatusNotFound {
+		t.Errorf("missing segment status = %d, want 404", w.Code)
+	}
+	if location := follow(handler, "/search/more?q=go&lang=en").Header().Get("Location"); location != "https://example.com/more?lang=en&q=go" {
+		t.Errorf("Location = %q, want the query filled in and the rest forwarded", location)
+	}
+
+	clock.Add(time.Hour)
+	if w := follow(handler, "/gh/golang"); w.Code != http.StatusGone {
+		t.Errorf("expired template with a missing segment status = %d, want 410", w.Code)
+	}
+}
+
+func TestRedirectDisabledLinks(t *testing.T) {
+	clock := newTestClock()
+	db, jwt := newTestDB(clock), newTestJWT(clock)
+	mustSetLink(t, db, Link{Slug: "paused", URL: "https://example.com", Disabled: true})
+	mustSetLink(t, db, Link{Slug: "moved", URL: "https://example.com", Disabled: true, FallbackURL: "https://example.org/moved"})
+
+	tests := []struct {
+		name     string
+		config   RedirectConfig
+		path     string
+		code     int
+		location string
+	}{
+		{"own page", RedirectConfig{}, "/paused", http.StatusServiceUnavailable, ""},
+		{"configured page", RedirectConfig{DisabledURL: "https://example.org/paused"}, "/paused", http.StatusFound, "https://example.org/paused"},
+		{"link's fallback", RedirectConfig{DisabledURL: "https://example.org/paused"}, "/moved", http.StatusFound, "https://example.org/moved"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := follow(epRedirectWithClock(db, jwt, clock, test.config), test.path)
+			if w.Code != test.code || w.Header().Get("Location") != test.location {
+				t.Errorf("status %d to %q, want %d to %q", w.Code, w.Header().Get("Location"), test.code, test.location)
+			}
+			if w.Header().Get("Cache-Control") != "no-store" {
+				t.Errorf("Cache-Control = %q, want no-store so the link works again once enabled", w.Header().Get("Cache-Control"))
+			}
+		})
+	}
+	if link := mustGetLink(t, db, "paused"); link.Clicks != 0 {
+		t.Errorf("clicks = %d, want visits to a disabled link left uncounted", link.Clicks)
+	}
+}
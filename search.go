@@ -0,0 +1,163 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Links are indexed by every three character piece of their text, so any substring of at least that
+// length can be looked up. Shorter searches match the start of words, which are indexed separately.
+const searchGramLength = 3
+
+// searchGramKey is the set of links whose text contains gram
+func searchGramKey(gram string) string {
+	return "search:" + gram
+}
+
+// searchPrefixKey is the set of links with a word starting with prefix
+func searchPrefixKey(prefix string) string {
+	return "search-prefix:" + prefix
+}
+
+// isSearchKey reports whether a key is part of the search index, which can always be rebuilt from the links
+func isSearchKey(key string) bool {
+	return strings.HasPrefix(key, "search:") || strings.HasPrefix(key, "search-prefix:")
+}
+
+// Only the start of a link's notes is searched, since every character indexed adds the link to another set
+const maxSearchedNotesLength = 200
+
+// searchFields is the text of a link that searches look through, lowercased
+func (link Link) searchFields() []string {
+	notes := []rune(link.Notes)
+	fields := []string{link.Slug, link.URL, link.Title, string(notes[:min(len(notes), maxSearchedNotesLength)])}
+	fields = append(fields, link.Aliases...)
+	fields = append(fields, link.Tags...)
+	for i, field := range fields {
+		fields[i] = strings.ToLower(field)
+	}
+	return fields
+}
+
+// searchKeys are the index sets a link belongs in
+func (link Link) searchKeys() []string {
+	return indexKeys(link.searchFields())
+}
+
+// indexKeys are the index sets for the pieces and word starts of some lowercased text
+func indexKeys(fields []string) []string {
+	keys := []string{}
+	for _, field := range fields {
+		runes := []rune(field)
+		for i := 0; i+searchGramLength <= len(runes); i++ {
+			keys = append(keys, searchGramKey(string(runes[i:i+searchGramLength])))
+		}
+		for _, word := range searchWords(field) {
+			runes := []rune(word)
+			for length := 1; length < searchGramLength && length <= len(runes); length++ {
+				keys = append(keys, searchPrefixKey(string(runes[:length])))
+			}
+		}
+	}
+	slices.Sort(keys)
+	return slices.Compact(keys)
+}
+
+// searchWords splits text into the words that short searches match the start of
+func searchWords(text string) []string {
+	return strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// searchTerms splits a search into lowercase terms, all of which a link has to match
+func searchTerms(search string) []string {
+	return strings.Fields(strings.ToLower(search))
+}
+
+// termKeys are the index sets a link has to be in to match a search term.
+// Being in all of them doesn't guarantee a match, since the pieces can be spread out, so matchesSearch has the final say.
+func termKeys(term string) []string {
+	runes := []rune(term)
+	if len(runes) < searchGramLength {
+		return []string{searchPrefixKey(term)}
+	}
+	keys := []string{}
+	for i := 0; i+searchGramLength <= len(runes); i++ {
+		keys = append(keys, searchGramKey(string(runes[i:i+searchGramLength])))
+	}
+	return keys
+}
+
+// matchesSearch reports whether every term is in the link's text.
+// Short terms have to start a word, longer ones can be anywhere.
+func (link Link) matchesSearch(terms []string) bool {
+	fields := link.searchFields()
+	for _, term := range terms {
+		matches := slices.ContainsFunc(fields, func(field string) bool {
+			if utf8.RuneCountInString(term) >= searchGramLength {
+				return strings.Contains(field, term)
+			}
+			return slices.ContainsFunc(searchWords(field), func(word string) bool {
+				return strings.HasPrefix(word, term)
+			})
+		})
+		if !matches {
+			return false
+		}
+	}
+	return true
+}
+
+// indexSearch moves a link from the index sets it was in to the ones it belongs in now
+func indexSearch(tx Tx, linkSlug string, oldKeys []string, newKeys []string) {
+	for _, key := range oldKeys {
+		if !slices.Contains(newKeys, key) {
+			tx.RemoveFromSet(key, linkSlug)
+		}
+	}
+	for _, key := range newKeys {
+		if !slices.Contains(oldKeys, key) {
+			tx.AddToSet(key, linkSlug)
+		}
+	}
+}
+
+// Longer searches would only be slower, not better
+const maxSearchLength = 200
+
+func epSearchLinks(db AdvancedDB, jwt JWTService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Verify user is authenticated
+		err := jwt.ReadAndValidateJWT(r)
+		if err != nil {
+			httpError(w, "JWT is invalid for searching links", http.StatusForbidden, err)
+			return
+		}
+
+		query := linkQueryFromRequest(r)
+		if len(query.Search) > maxSearchLength {
+			httpNewError(w, "Search is longer than "+strconv.Itoa(maxSearchLength)+" characters", http.StatusBadRequest)
+			return
+		}
+		page, err := db.GetLinks(r.Context(), query) // An empty search lists every link, like clearing the search box should
+		if err != nil {
+			httpError(w, "Failed to search links", http.StatusInternalServerError, err)
+			return
+		}
+		if wantsJSON(r) {
+			writeJSON(w, http.StatusOK, page)
+			return
+		}
+
+		// Render links using template
+		err = renderLinkCards(w, page)
+		if err != nil {
+			httpError(w, "Failed to render links", http.StatusInternalServerError, err)
+		}
+	}
+}
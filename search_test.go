@@ -0,0 +1,117 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+// search lists the slugs of the links matching a search, newest first
+func search(t *testing.T, db DB, words string) []string {
+	t.Helper()
+	page, err := db.GetLinks(t.Context(), LinkQuery{Search: words, Limit: 50})
+	if err != nil {
+		t.Fatalf("GetLinks(%q): %v", words, err)
+	}
+	return pageSlugs(page)
+}
+
+func TestSearchLinks(t *testing.T) {
+	db := newTestDB(newTestClock())
+	mustSetLink(t, db, Link{Slug: "docs", URL: "https://example.com/handbook", Title: "Team Docs", Tags: []string{"work/wiki"}})
+	mustSetLink(t, db, Link{Slug: "lunch", URL: "https://food.example.org", Notes: "Fridays only"})
+	err := db.AddAlias(t.Context(), "lunch", "menu")
+	if err != nil {
+		t.Fatalf("AddAlias: %v", err)
+	}
+
+	tests := map[string][]string{
+		"docs":          {"docs"},
+		"HANDBOOK":      {"docs"},
+		"book":          {"docs"},          // Anywhere in a word
+		"ex":            {"lunch", "docs"}, // Short searches start a word
+		"am":            {},                // But only start one
+		"wiki":          {"docs"},
+		"friday":        {"lunch"},
+		"men":           {"lunch"},
+		"team handbook": {"docs"},
+		"team lunch":    {},
+		"":              {"lunch", "docs"},
+	}
+	for words, want := range tests {
+		if got := search(t, db, words); !slices.Equal(got, want) {
+			t.Errorf("search %q = %v, want %v", words, got, want)
+		}
+	}
+}
+
+func TestSearchIndexFollowsChanges(t *testing.T) {
+	db := newTestDB(newTestClock())
+	ctx := t.Context()
+	mustSetLink(t, db, Link{Slug: "docs", URL: "https://example.com", Title: "Handbook"})
+
+	err := db.UpdateLink(ctx, "docs", "guide", "api", func(link *Link) error {
+		link.Title = "Manual"
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("UpdateLink: %v", err)
+	}
+	for words, want := range map[string][]string{"handbook": {}, "docs": {}, "manual": {"guide"}, "guide": {"guide"}} {
+		if got := search(t, db, words); !slices.Equal(got, want) {
+			t.Errorf("search %q after the update = %v, want %v", words, got, want)
+		}
+	}
+
+	err = db.TrashLink(ctx, "guide", "api")
+	if err != nil {
+		t.Fatalf("TrashLink: %v", err)
+	}
+	if got := search(t, db, "manual"); len(got) != 0 {
+		t.Errorf("search after trashing = %v, want nothing", got)
+	}
+	for _, key := range []string{searchGramKey("man"), searchPrefixKey("m")} {
+		members, _ := db.basicDB.GetSet(ctx, key)
+		if len(members) != 0 {
+			t.Errorf("%s = %v after trashing, want the link taken out", key, members)
+		}
+	}
+}
+
+func TestSearchOnlyIndexesStartOfNotes(t *testing.T) {
+	db := newTestDB(newTestClock())
+	notes := "early " + strings.Repeat("x", maxSearchedNotesLength) + " buried"
+	mustSetLink(t, db, Link{Slug: "long", URL: "https://example.com", Notes: notes})
+
+	if got := search(t, db, "early"); !slices.Equal(got, []string{"long"}) {
+		t.Errorf("search early = %v, want the link", got)
+	}
+	if got := search(t, db, "buried"); len(got) != 0 {
+		t.Errorf("search buried = %v, want words past the start of the notes left out", got)
+	}
+	if keys := mustGetLink(t, db, "long").searchKeys(); slices.Contains(keys, searchGramKey("bur")) {
+		t.Error("the end of the notes was indexed")
+	}
+}
+
+func TestUnindexLongNotes(t *testing.T) {
+	db := newTestDB(newTestClock())
+	ctx := t.Context()
+	link := Link{Slug: "long", URL: "https://example.com", Notes: "early " + strings.Repeat("x", maxSearchedNotesLength) + " buried"}
+	mustSetLink(t, db, link)
+	// As indexed before only the start of notes was
+	for _, key := range indexKeys([]string{strings.ToLower(link.Notes)}) {
+		db.basicDB.AddToSet(ctx, key, link.Slug)
+	}
+
+	err := unindexLongNotes(ctx, db)
+	if err != nil {
+		t.Fatalf("unindexLongNotes: %v", err)
+	}
+	if members, _ := db.basicDB.GetSet(ctx, searchGramKey("bur")); len(members) != 0 {
+		t.Errorf("%s = %v, want the link taken out", searchGramKey("bur"), members)
+	}
+	if got := search(t, db, "early"); !slices.Equal(got, []string{"long"}) {
+		t.Errorf("search early = %v, want the link still found", got)
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"nyooom/logging"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultSlugLength   = 6
+	defaultSlugAlphabet = "abcdefghijkmnopqrstuvwxyz23456789" // URL safe, lowercase, and without look-alikes like l and 1
+	maxSlugLength       = 32
+	maxSlugAttempts     = 10   // Random slugs to try before giving up on creating a link
+	maxSlugCrowding     = 0.01 // Longest fraction of the possible slugs of a length that can be taken before growing
+	slugGrowCollisions  = 3    // Collisions in a row that grow the slug length, in case the crowding estimate is off
+)
+
+// SlugConfig decides what random slugs look like
+type SlugConfig struct {
+	Length   int    // Shortest length of random slugs, longer ones are made when the shorter ones run out
+	Alphabet string // Characters random slugs are made from
+}
+
+// loadSlugConfig reads SLUG_LENGTH and SLUG_ALPHABET, panicking if they can't make valid slugs
+func loadSlugConfig() SlugConfig {
+	config := SlugConfig{
+		Length:   defaultSlugLength,
+		Alphabet: defaultSlugAlphabet,
+	}
+	if rawLength := os.Getenv("SLUG_LENGTH"); rawLength != "" {
+		length, err := strconv.Atoi(rawLength)
+		if err != nil || length < 3 || length > maxSlugLength {
+			panic("SLUG_LENGTH must be a number from 3 to " + strconv.Itoa(maxSlugLength) + ", got \"" + rawLength + "\"")
+		}
+		config.Length = length
+	}
+	if alphabet := os.Getenv("SLUG_ALPHABET"); alphabet != "" {
+		err := validateSlugAlphabet(alphabet)
+		if err != nil {
+			panic("SLUG_ALPHABET can't be used: " + err.Error())
+		}
+		config.Alphabet = alphabet
+	}
+	return config
+}
+
+func validateSlugAlphabet(alphabet string) error {
+	seen := map[rune]bool{}
+	for _, character := range alphabet {
+		if character <= ' ' || character > '~' {
+			return errors.New("slugs can only use printable ASCII characters")
+		}
+		if strings.ContainsRune(":/\\?#%", character) {
+			return errors.New(strconv.QuoteRune(character) + " can't be used in slugs")
+		}
+		if seen[character] {
+			return errors.New(strconv.QuoteRune(character) + " is in the alphabet twice")
+		}
+		seen[character] = true
+	}
+	if len(seen) < 2 {
+		return errors.New("at least two characters are needed")
+	}
+	return nil
+}
+
+// LengthFor is how long random slugs should be, given how many links exist and how many random slugs in a row were taken
+func (config SlugConfig) LengthFor(linkCount int, collisions int) int {
+	length := config.Length
+	possibleSlugs := 1.0
+	for range length {
+		possibleSlugs *= float64(len(config.Alphabet))
+	}
+	for float64(linkCount)/possibleSlugs > maxSlugCrowding && length < maxSlugLength {
+		length++
+		possibleSlugs *= float64(len(config.Alphabet))
+	}
+	return min(length+collisions/slugGrowCollisions, maxSlugLength)
+}
+
+// Generate makes a cryptographically random slug that isn't reserved
+func (config SlugConfig) Generate(length int) string {
+	for {
+		slug := randomString(config.Alphabet, length)
+		if !isReservedSlug(slug) {
+			return slug
+		}
+	}
+}
+
+// Whether /Demo finds the link at /demo. Links whose slugs only differ by case can't be created while this is on.
+var caseInsensitiveSlugs = strings.ToLower(os.Getenv("CASE_INSENSITIVE_SLUGS")) == "true"
+
+// reservedSlugs can't be used for links, since Nyooom's routes, internal keys or the admin has claimed them.
+// Filled in at startup by reserveSlugs and reserveRoute, before any links are created.
+var reservedSlugs = map[string]bool{}
+
+func isReservedSlug(slug string) bool {
+	return reservedSlugs[strings.ToLower(slug)]
+}
+
+// reserveSlugs reserves internal keys and the comma separated RESERVED_SLUGS
+func reserveSlugs() {
+	for _, key := range internalKeys {
+		reservedSlugs[key] = true
+	}
+	for slug := range strings.SplitSeq(os.Getenv("RESERVED_SLUGS"), ",") {
+		slug = strings.ToLower(strings.Trim(strings.TrimSpace(slug), "/"))
+		if slug != "" {
+			reservedSlugs[slug] = true
+		}
+	}
+}
+
+// reserveRoute reserves the first path segment of a route pattern, like "api" for "/api/create-link".
+// Patterns that start with a wildcard, like "/{id}", are where links live and don't reserve anything.
+func reserveRoute(pattern string) {
+	if _, path, hasMethod := strings.Cut(pattern, " "); hasMethod {
+		pattern = path
+	}
+	_, path, _ := strings.Cut(pattern, "/") // Drop any host
+	segment, _, _ := strings.Cut(path, "/")
+	if segment == "" || strings.HasPrefix(segment, "{") {
+		return
+	}
+	reservedSlugs[strings.ToLower(segment)] = true
+}
+
+// reportReservedLinks warns about links made before their slugs were reserved, which visitors can't reach
+func reportReservedLinks(db AdvancedDB) {
+	slugs, err := reservedLinkSlugs(context.Background(), db)
+	if err != nil {
+		logging.PrintErrStr("Failed to check links for reserved slugs: " + err.Error())
+		return
+	}
+	for _, slug := range slugs {
+		logging.PrintErrStr("Link or alias \"" + slug + "\" uses a reserved slug and may not redirect, rename or remove it from the dashboard")
+	}
+}
+
+// reservedLinkSlugs lists the slugs and aliases of links that have since been reserved
+func reservedLinkSlugs(ctx context.Context, db AdvancedDB) ([]string, error) {
+	linkSlugs, err := db.GetLinkSlugs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	reserved := []string{}
+	for _, linkSlug := range linkSlugs {
+		link, err := db.GetLink(ctx, linkSlug)
+		if errors.Is(err, ErrLinkNotFound) { // Deleted since the slugs were listed
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		for _, slug := range append([]string{link.Slug}, link.Aliases...) {
+			if isReservedSlug(slug) {
+				reserved = append(reserved, slug)
+			}
+		}
+	}
+	return reserved, nil
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"errors"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestGenerateSlug(t *testing.T) {
+	config := loadSlugConfig()
+	seen := map[string]bool{}
+	for range 100 {
+		slug := config.Generate(defaultSlugLength)
+		if len(slug) != defaultSlugLength || strings.Trim(slug, defaultSlugAlphabet) != "" {
+			t.Fatalf("Generate = %q, want %d characters from the alphabet", slug, defaultSlugLength)
+		}
+		if seen[slug] {
+			t.Fatalf("Generate made %q twice in 100 tries", slug)
+		}
+		seen[slug] = true
+	}
+}
+
+func TestGenerateSkipsReservedSlugs(t *testing.T) {
+	reservedSlugs["a"] = true
+	t.Cleanup(func() { delete(reservedSlugs, "a") })
+
+	config := SlugConfig{Length: 1, Alphabet: "aB"}
+	for range 20 {
+		if slug := config.Generate(1); slug != "B" {
+			t.Fatalf("Generate = %q, want the only slug that isn't reserved", slug)
+		}
+	}
+}
+
+func TestSlugLengthFor(t *testing.T) {
+	config := SlugConfig{Length: 3, Alphabet: "ab"} // 8 possible slugs of the shortest length
+	tests := []struct {
+		linkCount  int
+		collisions int
+		want       int
+	}{
+		{0, 0, 3},
+		{0, slugGrowCollisions - 1, 3},
+		{0, slugGrowCollisions, 4},
+		{1, 0, 7}, // 1 in 128 is the most crowded a length can get
+		{2, 0, 8},
+		{0, 1000, maxSlugLength},
+	}
+	for _, test := range tests {
+		if got := config.LengthFor(test.linkCount, test.collisions); got != test.want {
+			t.Errorf("LengthFor(%d, %d) = %d, want %d", test.linkCount, test.collisions, got, test.want)
+		}
+	}
+}
+
+func TestLoadSlugConfig(t *testing.T) {
+	t.Setenv("SLUG_LENGTH", "8")
+	t.Setenv("SLUG_ALPHABET", "ABCDEF")
+	config := loadSlugConfig()
+	if config.Length != 8 || config.Alphabet != "ABCDEF" {
+		t.Errorf("config = %+v, want the environment's", config)
+	}
+
+	for name, env := range map[string][2]string{
+		"too short":       {"SLUG_LENGTH", "2"},
+		"too long":        {"SLUG_LENGTH", "33"},
+		"not a number":    {"SLUG_LENGTH", "six"},
+		"slash":           {"SLUG_ALPHABET", "ab/"},
+		"repeated":        {"SLUG_ALPHABET", "abca"},
+		"one character":   {"SLUG_ALPHABET", "a"},
+		"non-ASCII":       {"SLUG_ALPHABET", "abé"},
+		"space":           {"SLUG_ALPHABET", "a b"},
+		"percent escapes": {"SLUG_ALPHABET", "ab%"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv("SLUG_LENGTH", "")
+			t.Setenv("SLUG_ALPHABET", "")
+			t.Setenv(env[0], env[1])
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s=%q was accepted", env[0], env[1])
+				}
+			}()
+			loadSlugConfig()
+		})
+	}
+}
+
+// withReservedSlugs puts the reserved slugs back how they were after a test
+func withReservedSlugs(t *testing.T) {
+	t.Helper()
+	original := maps.Clone(reservedSlugs)
+	t.Cleanup(func() { reservedSlugs = original })
+}
+
+func TestReserveRoute(t *testing.T) {
+	withReservedSlugs(t)
+	for _, pattern := range []string{"/api/create-link", "POST /Login", "example.com/static/", "/{id}", "/", "GET /{id}/{rest...}"} {
+		reserveRoute(pattern)
+	}
+	for slug, want := range map[string]bool{"api": true, "login": true, "LOGIN": true, "static": true, "{id}": false, "": false, "docs": false} {
+		if got := isReservedSlug(slug); got != want {
+			t.Errorf("isReservedSlug(%q) = %v, want %v", slug, got, want)
+		}
+	}
+}
+
+func TestReserveSlugs(t *testing.T) {
+	withReservedSlugs(t)
+	t.Setenv("RESERVED_SLUGS", " Admin, /help/ ,,status")
+	reserveSlugs()
+	for _, slug := range append([]string{"admin", "ADMIN", "help", "status"}, internalKeys...) {
+		if !isReservedSlug(slug) {
+			t.Errorf("%q isn't reserved", slug)
+		}
+	}
+}
+
+func TestReservedSlugsCantBeUsed(t *testing.T) {
+	withReservedSlugs(t)
+	reservedSlugs["admin"] = true
+	clock := newTestClock()
+	db, jwt := newTestDB(clock), newTestJWT(clock)
+	mustSetLink(t, db, Link{Slug: "docs", URL: "https://example.com"})
+
+	w := postForm(t, jwt, epCreateLink(db, jwt, clock, loadSlugConfig(), NewTitleFetcher(nil)), "/api/create-link", url.Values{"slug": {"Admin"}, "url": {"https://example.org"}})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("creating a reserved slug: status = %d, want 400", w.Code)
+	}
+	w = postForm(t, jwt, epUpdateLink(db, jwt, clock, NewTitleFetcher(nil)), "/api/update-link", url.Values{"slug": {"docs"}, "new_slug": {"admin"}})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("renaming to a reserved slug: status = %d, want 400", w.Code)
+	}
+	w = postForm(t, jwt, epAddAlias(db, jwt), "/api/add-alias", url.Values{"slug": {"docs"}, "alias": {"admin"}})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("adding a reserved alias: status = %d, want 400", w.Code)
+	}
+	if _, err := db.ResolveLink(t.Context(), "admin"); !errors.Is(err, ErrLinkNotFound) {
+		t.Errorf("ResolveLink(admin) = %v, want no link", err)
+	}
+}
+
+func TestReservedLinkSlugs(t *testing.T) {
+	withReservedSlugs(t)
+	db := newTestDB(newTestClock())
+	mustSetLink(t, db, Link{Slug: "status", URL: "https://example.com"})
+	mustSetLink(t, db, Link{Slug: "docs", URL: "https://example.com/docs"})
+	err := db.AddAlias(t.Context(), "docs", "help")
+	if err != nil {
+		t.Fatalf("AddAlias: %v", err)
+	}
+
+	reservedSlugs["status"] = true
+	reservedSlugs["help"] = true
+	slugs, err := reservedLinkSlugs(t.Context(), db)
+	if err != nil {
+		t.Fatalf("reservedLinkSlugs: %v", err)
+	}
+	slices.Sort(slugs)
+	if !slices.Equal(slugs, []string{"help", "status"}) {
+		t.Errorf("reservedLinkSlugs = %v, want the reserved slug and alias", slugs)
+	}
+}
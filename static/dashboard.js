@@ -16,7 +16,7 @@ const fullDateFormatter = new Intl.DateTimeFormat("default", {
 
 // Format timestamp to user's local timezone
 function formatTimestamps() {
-	const timestampElements = document.querySelectorAll(".link-last-click");
+	const timestampElements = document.querySelectorAll("[data-timestamp]");
 	timestampElements.forEach(element => {
 		const timestamp = element.getAttribute("data-timestamp");
 		if (timestamp) {
@@ -30,8 +30,32 @@ function formatTimestamps() {
 			}
 		}
 	});
+
+	// Prefill date inputs, which only understand local time without a timezone
+	document.querySelectorAll("input[data-timestamp-value]").forEach(input => {
+		input.value = toLocalInputValue(new Date(input.getAttribute("data-timestamp-value")));
+	});
+}
+
+// Format a date the way datetime-local inputs expect, e.g. 2025-01-31T13:45
+function toLocalInputValue(date) {
+	const pad = number => String(number).padStart(2, "0");
+	return (
+		`${date.getFullYear()}-${pad(date.getMonth() + 1)}-${pad(date.getDate())}` +
+		`T${pad(date.getHours())}:${pad(date.getMinutes())}`
+	);
 }
 
+// Send datetime-local values to the server as full timestamps, since it doesn't know the user's timezone
+document.body.addEventListener("htmx:configRequest", function (event) {
+	const toTimestamp = value => (value ? new Date(value).toISOString() : value);
+	event.detail.elt.querySelectorAll?.('input[type="datetime-local"]').forEach(input => {
+		const value = event.detail.parameters[input.name];
+		// Repeated inputs, like scheduled changes, arrive as a list
+		event.detail.parameters[input.name] = Array.isArray(value) ? value.map(toTimestamp) : toTimestamp(value);
+	});
+});
+
 // Handle copy to clipboard functionality
 function copyToClipboard(event, slug) {
 	const url = window.location.origin + "/" + slug;
@@ -103,6 +127,20 @@ document.body.addEventListener("htmx:afterRequest", function (event) {
 			// Clear the form fields
 			document.getElementById("slug").value = "";
 			document.getElementById("url").value = "";
+			document.getElementById("not_before").value = "";
+			document.getElementById("expires_at").value = "";
+			document.getElementById("max_clicks").value = "";
+			document.getElementById("tags").value = "";
+			document.getElementById("title").value = "";
+			document.getElementById("notes").value = "";
+			document.getElementById("link_password").value = "";
+			document.getElementById("redirect_code").value = "302";
+			document.getElementById("kind").value = "standard";
+			document.getElementById("passthrough").checked = false;
+			document.getElementById("query_merge").value = "append";
+		} else if (event.detail.xhr.status === 409) {
+			responseDiv.className = "response-message error";
+			responseDiv.textContent = "A link with that slug already exists. Please choose another slug.";
 		} else {
 			responseDiv.className = "response-message error";
 			responseDiv.textContent = "Failed to create link. Please check your inputs and try again.";
@@ -114,8 +152,41 @@ document.body.addEventListener("htmx:afterRequest", function (event) {
 		}, 5000);
 	}
 
+	if (event.detail.elt.classList.contains("edit-link-form") && !event.detail.successful) {
+		if (event.detail.xhr.status === 409) {
+			alert("A link with that slug already exists. Please choose another slug.");
+		} else {
+			alert("Failed to update link. Please check your inputs and try again.");
+		}
+	}
+
+	if (event.detail.elt.classList.contains("bulk-tags-form") && !event.detail.successful) {
+		alert("Failed to tag links. Please select some links and enter tags to add or remove.");
+	}
+
+	if (event.detail.elt.closest(".bulk-tags-form, .toggle-link-form") && event.detail.elt.matches("button") && !event.detail.successful) {
+		alert("Failed to change links. Please select some links and try again.");
+	}
+
+	if (event.detail.elt.classList.contains("import-links-form") && !event.detail.successful) {
+		document.getElementById("import-report").innerHTML = "";
+		alert(`Failed to import links: ${event.detail.xhr.responseText}`);
+	}
+
+	if (event.detail.elt.classList.contains("rollback-form") && !event.detail.successful) {
+		alert("Failed to roll back the link. Its old destination may no longer be allowed.");
+	}
+
+	if (event.detail.elt.classList.contains("trash-form") && !event.detail.successful) {
+		if (event.detail.xhr.status === 409) {
+			alert("Another link already uses that slug, so this one can't be restored.");
+		} else {
+			alert("Failed to change the trash. Please refresh the page and try again.");
+		}
+	}
+
 	// Format timestamps after links are loaded/refreshed
-	if (target.id === "links-container") {
+	if (target.id === "links-container" || target.id === "trash-container" || target.classList.contains("link-history")) {
 		formatTimestamps();
 	}
 });
@@ -142,9 +213,32 @@ document.body.addEventListener("htmx:responseError", function (event) {
 	}
 });
 
+// Re-sort links from the first page
+function sortLinks() {
+	goToLinksPage(0);
+}
+
+// Show the page of links starting at offset, keeping the current sort
+function goToLinksPage(offset) {
+	document.getElementById("links-offset").value = offset;
+	htmx.trigger("#links-container", "refreshLinks");
+}
+
+// Show only links with a tag, or every link if tag is empty
+function filterByTag(tag) {
+	document.getElementById("links-tag").value = tag;
+	goToLinksPage(0);
+}
+
+// Show or hide the edit form of the card containing element
+function toggleEditLink(element) {
+	const forms = element.closest(".link-card").querySelectorAll(".edit-link-form");
+	forms.forEach(form => (form.hidden = !form.hidden));
+}
+
 // Handle delete link
 function deleteLink(slug) {
-	if (!confirm(`Are you sure you want to delete the link "${slug}"?`)) {
+	if (!confirm(`Move the link "${slug}" to the trash? It stops redirecting until it's restored.`)) {
 		return;
 	}
 
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"nyooom/logging"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	maxTagLength   = 50
+	maxTagsPerLink = 20
+)
+
+// TagCount is a tag, or a folder of tags, along with how many links are in it.
+// Tags like "work/docs" are in the folder "work", and links in them count towards it.
+type TagCount struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// Depth is how many folders the tag is inside of, for indenting the sidebar
+func (tag TagCount) Depth() int {
+	return strings.Count(tag.Name, "/")
+}
+
+// Label is the tag's name within its folder
+func (tag TagCount) Label() string {
+	return tag.Name[strings.LastIndex(tag.Name, "/")+1:]
+}
+
+// normalizeTag puts a tag in the form it's stored in, lowercase and without stray slashes
+func normalizeTag(rawTag string) (string, error) {
+	tag := strings.ToLower(strings.TrimSpace(rawTag))
+	tag = strings.Trim(tag, "/")
+	if tag == "" {
+		return "", errors.New("Invalid tag: tag is empty")
+	}
+	if len(tag) > maxTagLength {
+		return "", errors.New("Invalid tag: " + tag + " is longer than " + strconv.Itoa(maxTagLength) + " characters")
+	}
+	if strings.ContainsFunc(tag, func(r rune) bool { return r < ' ' || r == 0x7f || r == ',' }) {
+		return "", errors.New("Invalid tag: " + tag + " contains commas or control characters")
+	}
+	for folder := range strings.SplitSeq(tag, "/") {
+		if strings.TrimSpace(folder) == "" {
+			return "", errors.New("Invalid tag: " + tag + " has an empty folder")
+		}
+	}
+	return tag, nil
+}
+
+// parseTags reads tags from form values, each of which can hold several comma separated tags.
+// Tags are normalized, and duplicates are dropped while keeping the order they were given in.
+func parseTags(values []string) ([]string, error) {
+	tags := []string{}
+	for _, value := range values {
+		for rawTag := range strings.SplitSeq(value, ",") {
+			if strings.TrimSpace(rawTag) == "" { // Like a trailing comma
+				continue
+			}
+			tag, err := normalizeTag(rawTag)
+			if err != nil {
+				return nil, err
+			}
+			if !slices.Contains(tags, tag) {
+				tags = append(tags, tag)
+			}
+		}
+	}
+	if len(tags) > maxTagsPerLink {
+		return nil, errors.New("Invalid tags: links can have at most " + strconv.Itoa(maxTagsPerLink) + " tags")
+	}
+	return tags, nil
+}
+
+// tagFolders are the folders a tag is inside of, along with the tag itself, like "a", "a/b" and "a/b/c" for "a/b/c"
+func tagFolders(tag string) []string {
+	folders := []string{}
+	for i, r := range tag {
+		if r == '/' {
+			folders = append(folders, tag[:i])
+		}
+	}
+	return append(folders, tag)
+}
+
+// compareTags sorts tags folder by folder, so every folder is directly followed by its contents
+func compareTags(a string, b string) int {
+	return slices.Compare(strings.Split(a, "/"), strings.Split(b, "/"))
+}
+
+func epTagLinks(db AdvancedDB, jwt JWTService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Verify user is authenticated
+		err := jwt.ReadAndValidateJWT(r)
+		if err != nil {
+			httpError(w, "JWT is invalid for tagging links", http.StatusForbidden, err)
+			return
+		}
+		if r.Method != http.MethodPost { // Only allow POST requests
+			httpNewError(w, "Method not allowed for tagging links", http.StatusMethodNotAllowed)
+			return
+		}
+		if isJSONRequest(r) {
+			err = readJSONForm(r)
+		} else {
+			err = r.ParseForm()
+		}
+		if err != nil {
+			httpError(w, "Failed to read tags", http.StatusBadRequest, err)
+			return
+		}
+
+		linkSlugs := r.Form["slug"]
+		addTags, err := parseTags(r.Form["add_tags"])
+		if err != nil {
+			httpError(w, "Failed to read tags to add", http.StatusBadRequest, err)
+			return
+		}
+		removeTags, err := parseTags(r.Form["remove_tags"])
+		if err != nil {
+			httpError(w, "Failed to read tags to remove", http.StatusBadRequest, err)
+			return
+		}
+		if len(linkSlugs) == 0 || len(addTags)+len(removeTags) == 0 {
+			httpNewError(w, "Pick some links and tags to add or remove", http.StatusBadRequest)
+			return
+		}
+
+		links, err := db.UpdateLinksTags(r.Context(), linkSlugs, addTags, removeTags)
+		if errors.Is(err, ErrLinkNotFound) {
+			httpError(w, "Some of the links don't exist, so none were tagged", http.StatusNotFound, err)
+			return
+		} else if errors.Is(err, ErrTooManyTags) {
+			httpError(w, "Links can't have more than "+strconv.Itoa(maxTagsPerLink)+" tags, so none were tagged", http.StatusBadRequest, err)
+			return
+		} else if err != nil {
+			httpError(w, "Failed to tag links in database", http.StatusInternalServerError, err)
+			return
+		}
+		logging.Println("Tagged " + strconv.Itoa(len(links)) + " links")
+		if wantsJSON(r) {
+			writeJSON(w, http.StatusOK, links)
+			return
+		}
+
+		page, err := db.GetLinks(r.Context(), linkQueryFromRequest(r))
+		if err != nil {
+			httpError(w, "Failed to get links", http.StatusInternalServerError, err)
+			return
+		}
+
+		// Render links using template
+		err = renderLinkCards(w, page)
+		if err != nil {
+			httpError(w, "Failed to render links", http.StatusInternalServerError, err)
+		}
+	}
+}
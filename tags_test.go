@@ -0,0 +1,152 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+func TestParseTags(t *testing.T) {
+	tags, err := parseTags([]string{" Work, docs/API/ ,", "work"})
+	if err != nil || !slices.Equal(tags, []string{"work", "docs/api"}) {
+		t.Errorf("parseTags = %v, %v, want [work docs/api]", tags, err)
+	}
+	for _, rawTag := range []string{"/", "a//b", "a/ /b", "tab\there", "x" + string(make([]byte, maxTagLength))} {
+		if _, err := parseTags([]string{rawTag}); err == nil {
+			t.Errorf("parseTags(%q) succeeded, want an error", rawTag)
+		}
+	}
+	tooMany := []string{}
+	for i := range maxTagsPerLink + 1 {
+		tooMany = append(tooMany, "tag"+strconv.Itoa(i))
+	}
+	if _, err := parseTags(tooMany); err == nil {
+		t.Errorf("parseTags accepted %d tags", len(tooMany))
+	}
+}
+
+func TestTagFolders(t *testing.T) {
+	if folders := tagFolders("a/b/c"); !slices.Equal(folders, []string{"a", "a/b", "a/b/c"}) {
+		t.Errorf("tagFolders(a/b/c) = %v", folders)
+	}
+	tags := []string{"b", "a/c", "a-z", "a", "a/b/c"}
+	slices.SortFunc(tags, compareTags)
+	if !slices.Equal(tags, []string{"a", "a/b/c", "a/c", "a-z", "b"}) {
+		t.Errorf("sorted tags = %v, want each folder followed by its contents", tags)
+	}
+}
+
+func TestGetTagsCountsFolders(t *testing.T) {
+	db := newTestDB(newTestClock())
+	mustSetLink(t, db, Link{Slug: "one", URL: "https://example.com/1", Tags: []string{"work/docs", "home"}})
+	mustSetLink(t, db, Link{Slug: "two", URL: "https://example.com/2", Tags: []string{"work/docs", "work"}})
+	mustSetLink(t, db, Link{Slug: "three", URL: "https://example.com/3", Tags: []string{"work/meetings"}})
+
+	tags, err := db.GetTags(t.Context())
+	if err != nil {
+		t.Fatalf("GetTags: %v", err)
+	}
+	want := []TagCount{{"home", 1}, {"work", 3}, {"work/docs", 2}, {"work/meetings", 1}}
+	if !slices.Equal(tags, want) {
+		t.Errorf("GetTags = %v, want %v", tags, want)
+	}
+
+	page, err := db.GetLinks(t.Context(), LinkQuery{Tags: []string{"work"}, Limit: 10})
+	if err != nil {
+		t.Fatalf("GetLinks: %v", err)
+	}
+	if slugs := pageSlugs(page); !slices.Equal(slugs, []string{"three", "two", "one"}) {
+		t.Errorf("links in the work folder = %v, want all three", slugs)
+	}
+}
+
+func TestUnusedTagsArePruned(t *testing.T) {
+	db := newTestDB(newTestClock())
+	ctx := t.Context()
+	mustSetLink(t, db, Link{Slug: "one", URL: "https://example.com/1", Tags: []string{"draft", "shared"}})
+	mustSetLink(t, db, Link{Slug: "two", URL: "https://example.com/2", Tags: []string{"shared", "old"}})
+	allTags := func() []string {
+		t.Helper()
+		tags, err := db.basicDB.GetSet(ctx, allTagsKey)
+		if err != nil {
+			t.Fatalf("GetSet(%s): %v", allTagsKey, err)
+		}
+		slices.Sort(tags)
+		return tags
+	}
+
+	_, err := db.UpdateLinksTags(ctx, []string{"one", "two"}, nil, []string{"draft", "shared"})
+	if err != nil {
+		t.Fatalf("UpdateLinksTags: %v", err)
+	}
+	if tags := allTags(); !slices.Equal(tags, []string{"old"}) {
+		t.Errorf("tags after untagging = %v, want [old]", tags)
+	}
+
+	err = db.UpdateLink(ctx, "two", "renamed", "api", func(link *Link) error {
+		link.Tags = []string{"new"}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("UpdateLink: %v", err)
+	}
+	if tags := allTags(); !slices.Equal(tags, []string{"new"}) {
+		t.Errorf("tags after renaming and retagging = %v, want [new]", tags)
+	}
+
+	err = db.TrashLink(ctx, "renamed", "api")
+	if err != nil {
+		t.Fatalf("TrashLink: %v", err)
+	}
+	if tags := allTags(); len(tags) != 0 {
+		t.Errorf("tags after trashing = %v, want none", tags)
+	}
+	_, err = db.RestoreLink(ctx, "renamed", "api")
+	if err != nil {
+		t.Fatalf("RestoreLink: %v", err)
+	}
+	if tags := allTags(); !slices.Equal(tags, []string{"new"}) {
+		t.Errorf("tags after restoring = %v, want the link's tag back", tags)
+	}
+}
+
+func TestTagLinksIsAllOrNothing(t *testing.T) {
+	clock := newTestClock()
+	db, jwt := newTestDB(clock), newTestJWT(clock)
+	handler := epTagLinks(db, jwt)
+	mustSetLink(t, db, Link{Slug: "one", URL: "https://example.com/1", Tags: []string{"old"}})
+	full := []string{}
+	for i := range maxTagsPerLink {
+		full = append(full, "tag"+strconv.Itoa(i))
+	}
+	mustSetLink(t, db, Link{Slug: "full", URL: "https://example.com/2", Tags: full})
+
+	w := postForm(t, jwt, handler, "/api/tag-links", url.Values{"slug": {"one", "missing"}, "add_tags": {"new"}})
+	if w.Code != http.StatusNotFound {
+		t.Errorf("tagging a missing link: status = %d, want 404", w.Code)
+	}
+	w = postForm(t, jwt, handler, "/api/tag-links", url.Values{"slug": {"one", "full"}, "add_tags": {"new"}})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("tagging past the limit: status = %d, want 400", w.Code)
+	}
+	if link := mustGetLink(t, db, "one"); !slices.Equal(link.Tags, []string{"old"}) {
+		t.Errorf("tags = %v after failed requests, want them untouched", link.Tags)
+	}
+
+	w = postJSON(t, jwt, handler, "/api/tag-links", `{"slug": ["one", "full", "one"], "add_tags": "new", "remove_tags": ["old", "tag0"]}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+	}
+	if link := mustGetLink(t, db, "one"); !slices.Equal(link.Tags, []string{"new"}) {
+		t.Errorf("one tags = %v, want [new]", link.Tags)
+	}
+	if link := mustGetLink(t, db, "full"); len(link.Tags) != maxTagsPerLink || !slices.Contains(link.Tags, "new") {
+		t.Errorf("full tags = %v, want tag0 swapped for new", link.Tags)
+	}
+	if _, err := db.UpdateLinksTags(t.Context(), []string{"missing"}, []string{"x"}, nil); !errors.Is(err, ErrLinkNotFound) {
+		t.Errorf("UpdateLinksTags(missing) error = %v, want ErrLinkNotFound", err)
+	}
+}
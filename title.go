@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"nyooom/logging"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+const (
+	maxTitleLength    = 200
+	titleFetchTimeout = 5 * time.Second
+	maxTitlePageSize  = 1 << 20 // Titles are in the head, so there's no need to read all of a huge page
+)
+
+// TitleFetcher looks up the <title> of a link's destination, so links get a title without typing one
+type TitleFetcher struct {
+	client *http.Client // Nil when fetching titles is turned off
+}
+
+func NewTitleFetcher(client *http.Client) TitleFetcher {
+	return TitleFetcher{client: client}
+}
+
+// loadTitleFetcher reads FETCH_LINK_TITLES. It's off by default, since it makes Nyooom request every destination it's given.
+func loadTitleFetcher() TitleFetcher {
+	if strings.ToLower(os.Getenv("FETCH_LINK_TITLES")) != "true" {
+		return TitleFetcher{}
+	}
+	return NewTitleFetcher(&http.Client{Timeout: titleFetchTimeout})
+}
+
+func (fetcher TitleFetcher) Enabled() bool {
+	return fetcher.client != nil
+}
+
+// Fetch reads the title of the web page at destination
+func (fetcher TitleFetcher) Fetch(ctx context.Context, destination string) (string, error) {
+	if !fetcher.Enabled() {
+		return "", errors.New("Could not fetch title of " + destination + ": fetching titles is turned off")
+	}
+	parsed, err := url.Parse(destination)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+		return "", errors.New("Could not fetch title of " + destination + ": only web pages have titles")
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, destination, nil)
+	if err != nil {
+		return "", errors.New("Could not fetch title of " + destination + ": " + err.Error())
+	}
+	request.Header.Set("Accept", "text/html,application/xhtml+xml")
+	request.Header.Set("User-Agent", "Nyooom link title fetcher")
+	response, err := fetcher.client.Do(request)
+	if err != nil {
+		return "", errors.New("Could not fetch title of " + destination + ": " + err.Error())
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return "", errors.New("Could not fetch title of " + destination + ": got status " + strconv.Itoa(response.StatusCode))
+	}
+	mediaType, _, _ := mime.ParseMediaType(response.Header.Get("Content-Type"))
+	if mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return "", errors.New("Could not fetch title of " + destination + ": " + mediaType + " isn't a web page")
+	}
+
+	title, err := findTitle(io.LimitReader(response.Body, maxTitlePageSize))
+	if err != nil {
+		return "", errors.New("Could not fetch title of " + destination + ": " + err.Error())
+	}
+	return title, nil
+}
+
+// findTitle reads an HTML page up to the end of its <title>
+func findTitle(page io.Reader) (string, error) {
+	tokenizer := html.NewTokenizer(page)
+	inTitle := false
+	var title strings.Builder
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			if errors.Is(tokenizer.Err(), io.EOF) {
+				return "", errors.New("page has no title")
+			}
+			return "", tokenizer.Err()
+		case html.StartTagToken:
+			name, _ := tokenizer.TagName()
+			inTitle = string(name) == "title"
+		case html.TextToken:
+			if inTitle {
+				title.Write(tokenizer.Text())
+			}
+		case html.EndTagToken:
+			name, _ := tokenizer.TagName()
+			if string(name) == "title" {
+				cleaned := cleanTitle(title.String())
+				if cleaned == "" {
+					return "", errors.New("page has an empty title")
+				}
+				return cleaned, nil
+			}
+		}
+	}
+}
+
+// cleanTitle collapses whitespace and shortens a title to what a link can store
+func cleanTitle(title string) string {
+	title = strings.Join(strings.Fields(title), " ")
+	runes := []rune(title)
+	if len(runes) > maxTitleLength {
+		title = strings.TrimSpace(string(runes[:maxTitleLength-1])) + "…"
+	}
+	return title
+}
+
+// FillTitle gives a link the title of its destination, keeping the title it has if that can't be fetched
+func (fetcher TitleFetcher) FillTitle(ctx context.Context, link *Link) {
+	if !fetcher.Enabled() || link.IsTemplate() { // Templates aren't a real page until they're filled in
+		return
+	}
+	title, err := fetcher.Fetch(ctx, link.URL)
+	if err != nil {
+		logging.PrintErrStr(err.Error())
+		return
+	}
+	link.Title = title
+}
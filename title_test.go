@@ -0,0 +1,116 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// titleServer serves a few pages for fetching titles from
+func titleServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	page := func(contentType string, body string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", contentType)
+			w.Write([]byte(body))
+		}
+	}
+	mux.Handle("/docs", page("text/html; charset=utf-8", "<html><head><title>\n  Team   Docs &amp; Guides\n</title></head><body><title>Not this</title></body></html>"))
+	mux.Handle("/long", page("text/html", "<title>"+strings.Repeat("a", maxTitleLength+50)+"</title>"))
+	mux.Handle("/untitled", page("text/html", "<html><body>Hello</body></html>"))
+	mux.Handle("/empty", page("text/html", "<title>  </title>"))
+	mux.Handle("/image", page("image/png", "<title>Not a page</title>"))
+	mux.Handle("/missing", http.NotFoundHandler())
+	mux.Handle("/agent", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<title>" + r.UserAgent() + "</title>"))
+	}))
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestFetchTitle(t *testing.T) {
+	server := titleServer(t)
+	fetcher := NewTitleFetcher(server.Client())
+
+	for path, want := range map[string]string{
+		"/docs":  "Team Docs & Guides",
+		"/long":  strings.Repeat("a", maxTitleLength-1) + "…",
+		"/agent": "Nyooom link title fetcher",
+	} {
+		title, err := fetcher.Fetch(t.Context(), server.URL+path)
+		if err != nil || title != want {
+			t.Errorf("Fetch(%s) = %q, %v, want %q", path, title, err, want)
+		}
+	}
+	for _, destination := range []string{
+		server.URL + "/untitled",
+		server.URL + "/empty",
+		server.URL + "/image",
+		server.URL + "/missing",
+		"mailto:team@example.com",
+	} {
+		if title, err := fetcher.Fetch(t.Context(), destination); err == nil {
+			t.Errorf("Fetch(%s) = %q, want an error", destination, title)
+		}
+	}
+}
+
+func TestTitleFetcherTurnedOff(t *testing.T) {
+	fetcher := NewTitleFetcher(nil)
+	if fetcher.Enabled() {
+		t.Fatal("fetcher without a client is enabled")
+	}
+	if _, err := fetcher.Fetch(t.Context(), "https://example.com"); err == nil {
+		t.Error("Fetch succeeded with fetching turned off")
+	}
+	link := Link{URL: "https://example.com", Title: "Kept"}
+	fetcher.FillTitle(t.Context(), &link)
+	if link.Title != "Kept" {
+		t.Errorf("title = %q, want it left alone", link.Title)
+	}
+}
+
+func TestCreateLinkFetchesTitle(t *testing.T) {
+	server := titleServer(t)
+	clock := newTestClock()
+	db, jwt := newTestDB(clock), newTestJWT(clock)
+	handler := epCreateLink(db, jwt, clock, loadSlugConfig(), NewTitleFetcher(server.Client()))
+
+	postForm(t, jwt, handler, "/api/create-link", url.Values{"slug": {"docs"}, "url": {server.URL + "/docs"}})
+	postForm(t, jwt, handler, "/api/create-link", url.Values{"slug": {"named"}, "url": {server.URL + "/docs"}, "title": {"Mine"}})
+	postForm(t, jwt, handler, "/api/create-link", url.Values{"slug": {"broken"}, "url": {server.URL + "/missing"}})
+	postForm(t, jwt, handler, "/api/create-link", url.Values{"slug": {"template"}, "url": {server.URL + "/{1}"}, "kind": {string(LinkKindTemplate)}})
+
+	for slug, want := range map[string]string{"docs": "Team Docs & Guides", "named": "Mine", "broken": "", "template": ""} {
+		if title := mustGetLink(t, db, slug).Title; title != want {
+			t.Errorf("%s title = %q, want %q", slug, title, want)
+		}
+	}
+}
+
+func TestUpdateLinkRefetchesTitle(t *testing.T) {
+	server := titleServer(t)
+	clock := newTestClock()
+	db, jwt := newTestDB(clock), newTestJWT(clock)
+	mustSetLink(t, db, Link{Slug: "docs", URL: server.URL + "/untitled", Title: "Old"})
+
+	w := postForm(t, jwt, epUpdateLink(db, jwt, clock, NewTitleFetcher(nil)), "/api/update-link", url.Values{"slug": {"docs"}, "fetch_title": {"on"}})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("fetching with fetching turned off: status = %d, want 400", w.Code)
+	}
+
+	// The title comes from where the link is about to point
+	handler := epUpdateLink(db, jwt, clock, NewTitleFetcher(server.Client()))
+	w = postForm(t, jwt, handler, "/api/update-link", url.Values{"slug": {"docs"}, "url": {server.URL + "/docs"}, "fetch_title": {"on"}})
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+	}
+	if link := mustGetLink(t, db, "docs"); link.Title != "Team Docs & Guides" {
+		t.Errorf("title = %q, want the new destination's", link.Title)
+	}
+}
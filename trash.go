@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"html/template"
+	"net/http"
+	"nyooom/logging"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultTrashRetentionDays = 30
+	trashSweepInterval        = time.Hour
+)
+
+// Deleted links are renamed to trashKey(slug), and their slugs are kept in the trashedLinksKey set.
+// Slugs can't contain colons, so trashed links never collide with live ones.
+const trashedLinksKey = "trash"
+
+func trashKey(slug string) string {
+	return "trash:" + slug
+}
+
+// TrashedLink is a deleted link waiting to be restored or purged
+type TrashedLink struct {
+	Link
+	DeletedAt time.Time `json:"deleted_at"`
+	PurgeAt   time.Time `json:"purge_at"` // When the link is permanently deleted if nobody restores it
+}
+
+// loadTrashRetention reads TRASH_RETENTION_DAYS, panicking if it isn't a positive number of days
+func loadTrashRetention() time.Duration {
+	days := defaultTrashRetentionDays
+	if rawDays := os.Getenv("TRASH_RETENTION_DAYS"); rawDays != "" {
+		var err error
+		days, err = strconv.Atoi(rawDays)
+		if err != nil || days < 1 {
+			panic("TRASH_RETENTION_DAYS must be a number of days of at least 1, got \"" + rawDays + "\"")
+		}
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// sweepTrash permanently deletes links once they've been in the trash for longer than retention, checking every trashSweepInterval
+func sweepTrash(db AdvancedDB, retention time.Duration) {
+	for {
+		purged, err := db.PurgeExpiredTrash(context.Background(), retention)
+		if err != nil {
+			logging.PrintErrStr("Failed to empty old links from the trash: " + err.Error())
+		} else if purged > 0 {
+			logging.Println("Purged " + strconv.Itoa(purged) + " links from the trash")
+		}
+		time.Sleep(trashSweepInterval)
+	}
+}
+
+// TrashPage is what trash-cards.html renders
+type TrashPage struct {
+	Links     []TrashedLink
+	Retention int // In days
+}
+
+func renderTrash(w http.ResponseWriter, page TrashPage) error {
+	tmpl, err := template.New("trash-cards.html").Funcs(linkTemplateFuncs).ParseFiles("static/trash-cards.html")
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "text/html")
+	return tmpl.Execute(w, page)
+}
+
+func epGetTrash(db AdvancedDB, jwt JWTService, retention time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Verify user is authenticated
+		err := jwt.ReadAndValidateJWT(r)
+		if err != nil {
+			httpError(w, "JWT is invalid for getting the trash", http.StatusForbidden, err)
+			return
+		}
+
+		links, err := db.GetTrash(r.Context(), retention)
+		if err != nil {
+			httpError(w, "Failed to get the trash", http.StatusInternalServerError, err)
+			return
+		}
+		if wantsJSON(r) {
+			writeJSON(w, http.StatusOK, links)
+			return
+		}
+
+		err = renderTrash(w, TrashPage{Links: links, Retention: int(retention / (24 * time.Hour))})
+		if err != nil {
+			httpError(w, "Failed to render the trash", http.StatusInternalServerError, err)
+		}
+	}
+}
+
+func epRestoreLink(db AdvancedDB, jwt JWTService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Verify user is authenticated
+		err := jwt.ReadAndValidateJWT(r)
+		if err != nil {
+			httpError(w, "JWT is invalid for restoring links", http.StatusForbidden, err)
+			return
+		}
+		if r.Method != http.MethodPost { // Only allow POST requests
+			httpNewError(w, "Method not allowed for restoring links", http.StatusMethodNotAllowed)
+			return
+		}
+		if isJSONRequest(r) {
+			err = readJSONForm(r)
+		} else {
+			err = r.ParseForm()
+		}
+		if err != nil {
+			httpError(w, "Failed to read link to restore", http.StatusBadRequest, err)
+			return
+		}
+
+		linkSlug := r.Form.Get("slug")
+		link, err := db.RestoreLink(r.Context(), linkSlug, requestActor(r))
+		if errors.Is(err, ErrLinkNotFound) {
+			httpError(w, "Link \""+linkSlug+"\" is not in the trash", http.StatusNotFound, err)
+			return
+		} else if errors.Is(err, ErrLinkExists) {
+			httpError(w, "Another link already uses \""+linkSlug+"\"", http.StatusConflict, err)
+			return
+		} else if err != nil {
+			httpError(w, "Failed to restore link \""+linkSlug+"\"", http.StatusInternalServerError, err)
+			return
+		}
+		logging.Println("Restored link \"" + linkSlug + "\" from the trash")
+		if wantsJSON(r) {
+			writeJSON(w, http.StatusOK, link)
+			return
+		}
+		w.Header().Set("HX-Trigger", "refreshLinks") // Both the links and the trash have changed
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func epPurgeLink(db AdvancedDB, jwt JWTService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Verify user is authenticated
+		err := jwt.ReadAndValidateJWT(r)
+		if err != nil {
+			httpError(w, "JWT is invalid for purging links", http.StatusForbidden, err)
+			return
+		}
+		if r.Method != http.MethodPost { // Only allow POST requests
+			httpNewError(w, "Method not allowed for purging links", http.StatusMethodNotAllowed)
+			return
+		}
+		if isJSONRequest(r) {
+			err = readJSONForm(r)
+		} else {
+			err = r.ParseForm()
+		}
+		if err != nil {
+			httpError(w, "Failed to read link to purge", http.StatusBadRequest, err)
+			return
+		}
+
+		linkSlug := r.Form.Get("slug")
+		err = db.PurgeLink(r.Context(), linkSlug)
+		if errors.Is(err, ErrLinkNotFound) {
+			httpError(w, "Link \""+linkSlug+"\" is not in the trash", http.StatusNotFound, err)
+			return
+		} else if err != nil {
+			httpError(w, "Failed to purge link \""+linkSlug+"\"", http.StatusInternalServerError, err)
+			return
+		}
+		logging.Println("Purged link \"" + linkSlug + "\" from the trash")
+		w.Header().Set("HX-Trigger", "refreshLinks")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("Link purged successfully"))
+	}
+}
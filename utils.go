@@ -1,18 +1,38 @@
 package main
 
 import (
-	"math/rand"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"mime"
 	"net/http"
+	"net/url"
 	"nyooom/logging"
+	"slices"
+	"strconv"
+	"strings"
 )
 
 func generateRandomString(length int) string {
 	// Charset is URL safe and easy to read
 	const charset = "abcdefghijkmnopqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	return randomString(charset, length)
+}
 
-	stringBase := make([]byte, length)
-	for i := range stringBase {
-		stringBase[i] = charset[rand.Intn(len(charset))]
+// randomString picks each character from an ASCII charset with crypto/rand, so the results can't be predicted
+func randomString(charset string, length int) string {
+	// Bytes past the last full multiple of the charset are skipped, otherwise the first characters would be picked more often
+	limit := 256 - 256%len(charset)
+	stringBase := make([]byte, 0, length)
+	randomBytes := make([]byte, length)
+	for len(stringBase) < length {
+		rand.Read(randomBytes) // Never fails, see crypto/rand
+		for _, randomByte := range randomBytes {
+			if int(randomByte) < limit && len(stringBase) < length {
+				stringBase = append(stringBase, charset[int(randomByte)%len(charset)])
+			}
+		}
 	}
 	return string(stringBase)
 }
@@ -26,3 +46,113 @@ func httpNewError(w http.ResponseWriter, message string, code int) {
 	http.Error(w, message, code)
 	logging.PrintErrStr(message)
 }
+
+// httpErrorPage is httpError for visitors, showing a friendly page instead of plain text
+func httpErrorPage(w http.ResponseWriter, title string, message string, code int, err error) {
+	logging.PrintErrStr(message, ": ", err.Error())
+	tmpl, tmplErr := template.ParseFiles("static/status-page.html")
+	if tmplErr != nil {
+		http.Error(w, message, code)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html")
+	w.WriteHeader(code)
+	tmpl.Execute(w, struct {
+		Title   string
+		Message string
+	}{title, message})
+}
+
+// requestActor describes who made a change. Nyooom has a single account, so this is how they made it.
+func requestActor(r *http.Request) string {
+	if wantsJSON(r) {
+		return "api"
+	}
+	return "dashboard"
+}
+
+// setDownloadName makes browsers save a response as a file, rather than showing it
+func setDownloadName(w http.ResponseWriter, filename string) {
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+}
+
+// isJSONRequest reports whether a request's body is JSON rather than a form
+func isJSONRequest(r *http.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mediaType == "application/json"
+}
+
+// wantsJSON reports whether a client would rather have JSON back than HTML for HTMX
+func wantsJSON(r *http.Request) bool {
+	return isJSONRequest(r) || strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
+// readJSONForm lets API clients send a JSON object with the same fields as the dashboard's forms.
+// The fields replace the request's form values, so handlers read them with FormValue as usual.
+func readJSONForm(r *http.Request) error {
+	var fields map[string]any
+	err := json.NewDecoder(http.MaxBytesReader(nil, r.Body, 1<<20)).Decode(&fields)
+	if err != nil {
+		return errors.New("Could not parse JSON body: " + err.Error())
+	}
+	r.PostForm, err = jsonFormValues(fields)
+	if err != nil {
+		return errors.New("Could not parse JSON body: " + err.Error())
+	}
+	r.Form = r.URL.Query()
+	for key, values := range r.PostForm {
+		r.Form[key] = append(values, r.Form[key]...)
+	}
+	return nil
+}
+
+// jsonFormValues turns the fields of a JSON object into form values, the way the dashboard would send them.
+// Lists become repeated values, and true becomes "on" like a checked checkbox.
+// Every field is kept even when it has no values, like false, null or [], so handlers can tell it was sent to clear a setting.
+func jsonFormValues(fields map[string]any) (url.Values, error) {
+	form := url.Values{}
+	for key, value := range fields {
+		values, ok := value.([]any)
+		if !ok {
+			values = []any{value}
+		}
+		form[key] = []string{}
+		for _, value := range values {
+			switch value := value.(type) {
+			case nil:
+			case string:
+				form.Add(key, value)
+			case float64:
+				form.Add(key, strconv.FormatFloat(value, 'f', -1, 64))
+			case bool:
+				if value { // Matches how checked checkboxes are sent
+					form.Add(key, "on")
+				}
+			default:
+				return nil, errors.New("field " + key + " must be a string, number, boolean or list")
+			}
+		}
+	}
+	return form, nil
+}
+
+// writeJSON sends a value to an API client
+func writeJSON(w http.ResponseWriter, code int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	err := json.NewEncoder(w).Encode(value)
+	if err != nil {
+		logging.PrintErrStr("Failed to write JSON response: " + err.Error())
+	}
+}
+
+// uniqueStrings drops repeated values, keeping the order they were first given in
+func uniqueStrings(values []string) []string {
+	unique := []string{}
+	for _, value := range values {
+		if !slices.Contains(unique, value) {
+			unique = append(unique, value)
+		}
+	}
+	return unique
+}